@@ -2,6 +2,8 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"os"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/grpclog"
@@ -34,6 +36,9 @@ func init() {
 	flag.BoolVar(&tracing, "trace", false, "enable tracing")
 	flag.Var(&loglevel, "loglevel", "debug|info|warn|warning|error|panic in any case")
 	flag.Parse()
+	if flag.Arg(0) == "validate" {
+		parseValidateFlags(flag.Args()[1:])
+	}
 	grpc.EnableTracing = tracing
 
 	logger.InitializeLogger(loglevel.ToLogrusLevel(), logoutput)
@@ -46,6 +51,9 @@ func main() {
 	//go func() { log.Println(http.ListenAndServe("[::]:8001", nil)) }()
 
 	err := repository.Init(configsPath)
+	if flag.Arg(0) == "validate" {
+		validate(err)
+	}
 	if err != nil {
 		log.Fatalf("unable to initialize filesystemRepository: %s", err)
 	}
@@ -66,3 +74,36 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// parseValidateFlags parse flags passed after the validate subcommand,
+// defaults are taken from flags passed before it
+func parseValidateFlags(args []string) {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	fs.StringVar(&logoutput, "logoutput", logoutput, "path to logfile")
+	fs.StringVar(&configsPath, "configspath", configsPath, "path to root of configs")
+	fs.Var(&loglevel, "loglevel", "debug|info|warn|warning|error|panic in any case")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "unexpected arguments for validate: %v\n", fs.Args())
+		fs.Usage()
+		os.Exit(2)
+	}
+}
+
+// validate check configs without starting combainer and exit,
+// exit code is 1 if problems are found
+func validate(initErr error) {
+	problems := combainer.ValidateConfigs()
+	if initErr != nil {
+		problems = append([]error{initErr}, problems...)
+	}
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		fmt.Fprintf(os.Stderr, "%d problems found in %s\n", len(problems), configsPath)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "configs in %s are valid\n", configsPath)
+	os.Exit(0)
+}
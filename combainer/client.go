@@ -9,15 +9,16 @@ import (
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/balancer/roundrobin"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/encoding/gzip"
 	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 
 	"github.com/combaine/combaine/common"
-	"github.com/combaine/combaine/common/hosts"
 	"github.com/combaine/combaine/repository"
 	"github.com/combaine/combaine/utils"
 	"github.com/combaine/combaine/worker"
@@ -33,6 +34,7 @@ type sessionParams struct {
 	WholeTime        time.Duration
 	PTasks           []worker.ParsingTask
 	AggTasks         []worker.AggregatingTask
+	cursorDir        string
 }
 
 // Client is a distributor of tasks across the computation grid
@@ -43,6 +45,10 @@ type Client struct {
 
 	conn    *grpc.ClientConn
 	aggConn *grpc.ClientConn
+
+	// cursors of incremental fetchers, they are saved only by scheduled clients
+	cursors     *cursorStore
+	saveCursors bool
 }
 
 func generateClientID() uint64 {
@@ -85,6 +91,14 @@ func NewClient(opt ...func(*Client) error) (*Client, error) {
 	return c, nil
 }
 
+// withSavedCursorsOpt make the client save cursors of incremental fetchers
+func withSavedCursorsOpt() func(*Client) error {
+	return func(c *Client) error {
+		c.saveCursors = true
+		return nil
+	}
+}
+
 // Close relases grpc.ClientConn
 func (cl *Client) Close() error {
 	return cl.conn.Close()
@@ -97,7 +111,7 @@ func (cl *Client) updateSessionParams(config string) (sp *sessionParams, err err
 
 	encodedParsingConfig, err := repository.GetParsingConfig(config)
 	if err != nil {
-		log.Errorf("unable to load .yaml or .json config: %s", err)
+		log.Errorf("unable to load .yaml, .yml or .json config: %s", err)
 		return nil, err
 	}
 
@@ -117,20 +131,10 @@ func (cl *Client) updateSessionParams(config string) (sp *sessionParams, err err
 
 	log.Infof("updating config metahost: %s", parsingConfig.Metahost)
 
-	hostFetcher, err := common.LoadHostFetcherWithCache(parsingConfig.HostFetcher, combainerCache)
+	allHosts, hostLabels, err := common.ResolveHosts(&parsingConfig, combainerCache)
 	if err != nil {
-		log.Errorf("Unable to construct SimpleFetcher: %s", err)
-		return
-	}
-
-	allHosts := make(hosts.Hosts)
-	for _, item := range parsingConfig.Groups {
-		hostsForGroup, err := hostFetcher.Fetch(item)
-		if err != nil {
-			log.WithFields(logrus.Fields{"error": err, "group": item}).Warn("unable to get hosts")
-			continue
-		}
-		allHosts.Merge(&hostsForGroup)
+		log.Errorf("Unable to resolve hosts: %s", err)
+		return nil, err
 	}
 
 	listOfHosts := allHosts.AllHosts()
@@ -151,16 +155,22 @@ func (cl *Client) updateSessionParams(config string) (sp *sessionParams, err err
 	packedParsingConfig, _ := utils.Pack(parsingConfig)
 	packedAggregationConfigs, _ := utils.Pack(aggregationConfigs)
 	packedHosts, _ := utils.Pack(allHosts)
+	packedHostLabels, _ := utils.Pack(hostLabels)
 
 	// Tasks for parsing
-	pTasks := make([]worker.ParsingTask, len(listOfHosts))
-	for idx, host := range listOfHosts {
-		pTasks[idx] = worker.ParsingTask{
-			Frame:                     new(worker.TimeFrame),
-			Host:                      host,
-			ParsingConfigName:         config,
-			EncodedParsingConfig:      packedParsingConfig,
-			EncodedAggregationConfigs: packedAggregationConfigs,
+	// datacenters are sorted to dispatch tasks in the same order each iteration
+	pTasks := make([]worker.ParsingTask, 0, len(listOfHosts))
+	for _, dc := range allHosts.Datacenters() {
+		for _, host := range allHosts[dc] {
+			pTasks = append(pTasks, worker.ParsingTask{
+				Frame:                     new(worker.TimeFrame),
+				Host:                      host,
+				Datacenter:                dc,
+				Labels:                    hostLabels[host],
+				ParsingConfigName:         config,
+				EncodedParsingConfig:      packedParsingConfig,
+				EncodedAggregationConfigs: packedAggregationConfigs,
+			})
 		}
 	}
 
@@ -174,6 +184,7 @@ func (cl *Client) updateSessionParams(config string) (sp *sessionParams, err err
 			EncodedParsingConfig:     packedParsingConfig,
 			EncodedAggregationConfig: packedAggregationConfig,
 			EncodedHosts:             packedHosts,
+			EncodedHostLabels:        packedHostLabels,
 		}
 	}
 
@@ -186,6 +197,7 @@ func (cl *Client) updateSessionParams(config string) (sp *sessionParams, err err
 		WholeTime:        wholeTime,
 		PTasks:           pTasks,
 		AggTasks:         aggTasks,
+		cursorDir:        cfg.MainSection.CursorDir,
 	}
 
 	log.Info("Session parametrs have been updated successfully")
@@ -208,6 +220,12 @@ func (cl *Client) Dispatch(iteration uint64, parsingConfigName string, sessionID
 		return errors.Wrap(err, "update session params")
 	}
 
+	if cl.cursors == nil {
+		if cl.cursors, err = loadCursorStore(params.cursorDir, parsingConfigName); err != nil {
+			log.Errorf("Failed to load cursors: %s", err)
+		}
+	}
+
 	log.Info("Start new iteration")
 
 	var wg sync.WaitGroup
@@ -228,6 +246,7 @@ func (cl *Client) Dispatch(iteration uint64, parsingConfigName string, sessionID
 		task.Frame.Previous = startTime.Unix()
 		task.Frame.Current = startTime.Add(params.WholeTime).Unix()
 		task.Id = sessionID
+		task.Cursors = cl.cursors.get(task.Host)
 
 		wg.Add(1)
 		tokens <- struct{}{} // acqure
@@ -240,6 +259,15 @@ func (cl *Client) Dispatch(iteration uint64, parsingConfigName string, sessionID
 	wg.Wait()
 	pcancel()
 	log.Infof("Parsing finished for %d hosts", len(parsingResult.Data))
+	if cl.saveCursors {
+		hosts := make([]string, len(params.PTasks))
+		for i, task := range params.PTasks {
+			hosts[i] = task.Host
+		}
+		if err := cl.cursors.save(hosts); err != nil {
+			log.Errorf("Failed to save cursors: %s", err)
+		}
+	}
 
 	// Aggregation phase
 	totalTasksAmount = len(params.AggTasks)
@@ -278,6 +306,9 @@ func (cl *Client) doParsing(ctx context.Context, task *worker.ParsingTask, m *sy
 	reply, err := c.DoParsing(ctx, task, grpc.Peer(&remote))
 	if err != nil {
 		log.Errorf("doParsing: reply error from %v: %s", remote.Addr, err)
+		if status.Code(err) == codes.Aborted {
+			cl.clientStats.AddFailedFetch()
+		}
 		cl.clientStats.AddFailedParsing()
 		return
 	}
@@ -286,6 +317,7 @@ func (cl *Client) doParsing(ctx context.Context, task *worker.ParsingTask, m *sy
 		r.Data[k] = v
 	}
 	m.Unlock()
+	cl.cursors.update(task.Host, reply.Cursors)
 	cl.clientStats.AddSuccessParsing()
 
 }
@@ -0,0 +1,109 @@
+package combainer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const defaultCursorDir = "/var/lib/combaine/cursors"
+
+// cursorStore keep cursors of incremental fetchers for hosts of a parsing config,
+// cursors of the host are keyed by source of multi fetcher.
+// Workers are stateless and get the cursor with each parsing task, so
+// worker restarts and failovers do not loose it, and the store is saved
+// on disk to survive combainer restarts.
+// The store is local to the combainer owning the config, when the config
+// is reassigned to another combainer, its cursors are not there and
+// incremental fetchers start from the current time frame, older cursors
+// saved before are clamped by fetchers (see timetail cursor_periods)
+type cursorStore struct {
+	sync.Mutex
+	path    string
+	cursors map[string]map[string]string
+	dirty   bool
+}
+
+// loadCursorStore read saved cursors of the config,
+// missing or broken file gives empty store
+func loadCursorStore(dir, config string) (*cursorStore, error) {
+	if dir == "" {
+		dir = defaultCursorDir
+	}
+	s := &cursorStore{
+		path:    filepath.Join(dir, config+".json"),
+		cursors: make(map[string]map[string]string),
+	}
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return s, err
+	}
+	if err := json.Unmarshal(data, &s.cursors); err != nil {
+		s.cursors = make(map[string]map[string]string)
+		return s, err
+	}
+	return s, nil
+}
+
+// get return copy of the host cursors, tasks of the host are sent concurrently
+func (s *cursorStore) get(host string) map[string]string {
+	s.Lock()
+	defer s.Unlock()
+	if len(s.cursors[host]) == 0 {
+		return nil
+	}
+	cursors := make(map[string]string, len(s.cursors[host]))
+	for source, cursor := range s.cursors[host] {
+		cursors[source] = cursor
+	}
+	return cursors
+}
+
+// update cursors of the host sources, cursors of other sources are kept
+func (s *cursorStore) update(host string, cursors map[string]string) {
+	s.Lock()
+	defer s.Unlock()
+	for source, cursor := range cursors {
+		if s.cursors[host] == nil {
+			s.cursors[host] = make(map[string]string)
+		}
+		s.cursors[host][source] = cursor
+		s.dirty = true
+	}
+}
+
+// save write updated cursors of hosts present in the config,
+// cursors of removed hosts are dropped
+func (s *cursorStore) save(hosts []string) error {
+	s.Lock()
+	if !s.dirty {
+		s.Unlock()
+		return nil
+	}
+	s.dirty = false
+	actual := make(map[string]map[string]string, len(hosts))
+	for _, host := range hosts {
+		if cursor, ok := s.cursors[host]; ok {
+			actual[host] = cursor
+		}
+	}
+	s.cursors = actual
+	data, err := json.Marshal(actual)
+	s.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
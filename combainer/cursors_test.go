@@ -0,0 +1,45 @@
+package combainer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCursorStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "combaine-cursors")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := loadCursorStore(dir, "config")
+	assert.NoError(t, err)
+	assert.Nil(t, s.get("host1"))
+
+	s.update("host1", map[string]string{"": "100", "access": "10"})
+	s.update("host2", map[string]string{"": "200"})
+	s.update("host1", map[string]string{"access": "11"})
+	s.update("host1", nil)
+	assert.Equal(t, map[string]string{"": "100", "access": "11"}, s.get("host1"))
+	// returned cursors are a copy
+	s.get("host1")["access"] = "12"
+	assert.Equal(t, "11", s.get("host1")["access"])
+	assert.NoError(t, s.save([]string{"host1", "host3"}))
+
+	s, err = loadCursorStore(dir, "config")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]map[string]string{"host1": {"": "100", "access": "11"}}, s.cursors)
+
+	// nothing is written without updates
+	assert.NoError(t, os.Remove(filepath.Join(dir, "config.json")))
+	assert.NoError(t, s.save([]string{"host1"}))
+	_, err = os.Stat(filepath.Join(dir, "config.json"))
+	assert.True(t, os.IsNotExist(err))
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "broken.json"), []byte("{"), 0644))
+	s, err = loadCursorStore(dir, "broken")
+	assert.Error(t, err)
+	assert.NotNil(t, s.cursors)
+}
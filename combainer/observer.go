@@ -13,6 +13,7 @@ import (
 	"github.com/kr/pretty"
 	"github.com/sirupsen/logrus"
 
+	"github.com/combaine/combaine/common/cache"
 	"github.com/combaine/combaine/repository"
 	"github.com/combaine/combaine/utils"
 )
@@ -22,6 +23,7 @@ type StatInfo struct {
 	ParsingSuccess   int64
 	ParsingFailed    int64
 	ParsingTotal     int64
+	FetchFailed      int64
 	AggregateSuccess int64
 	AggregateFailed  int64
 	AggregateTotal   int64
@@ -38,6 +40,7 @@ type info struct {
 	GoRoutines int
 	Files      OpenFiles
 	Clients    map[string]*StatInfo
+	Cache      *cache.Stats
 }
 
 // GlobalObserver is storage for client registations
@@ -100,6 +103,12 @@ func Dashboard(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var cacheStats *cache.Stats
+	if combainerCache != nil {
+		s := combainerCache.Stats()
+		cacheStats = &s
+	}
+
 	if err := json.NewEncoder(w).Encode(info{
 		GoRoutines: runtime.NumGoroutine(),
 		Files: OpenFiles{
@@ -107,12 +116,66 @@ func Dashboard(w http.ResponseWriter, r *http.Request) {
 			limit,
 		},
 		Clients: stats,
+		Cache:   cacheStats,
 	}); err != nil {
 		fmt.Fprintf(w, `{"error": "unable to dump json %s"`, err)
 		return
 	}
 }
 
+// CacheEntries list entries of hosts cache with their counters
+func CacheEntries(s ServerContext, w http.ResponseWriter, r *http.Request) {
+	if combainerCache == nil {
+		http.Error(w, "cache is not initialized", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(combainerCache.Entries())
+}
+
+// InvalidateCache mark keys given by `key` query parameters expired in hosts cache,
+// so they are fetched again, all keys are expired only with explicit `all=1`
+func InvalidateCache(s ServerContext, w http.ResponseWriter, r *http.Request) {
+	if combainerCache == nil {
+		http.Error(w, "cache is not initialized", http.StatusServiceUnavailable)
+		return
+	}
+	query := r.URL.Query()
+	keys := query["key"]
+	invalidated := 0
+	switch {
+	case query.Get("all") == "1":
+		if len(keys) > 0 {
+			http.Error(w, "`key` and `all` are mutually exclusive", http.StatusBadRequest)
+			return
+		}
+		invalidated = combainerCache.Purge()
+		logrus.Infof("Hosts cache is purged, %d keys expired", invalidated)
+	case len(keys) == 0:
+		http.Error(w, "`key` is required, use `all=1` to invalidate all keys", http.StatusBadRequest)
+		return
+	default:
+		for _, key := range keys {
+			if key == "" {
+				http.Error(w, "empty `key` is not allowed", http.StatusBadRequest)
+				return
+			}
+		}
+		for _, key := range keys {
+			if combainerCache.Invalidate(key) {
+				invalidated++
+				logrus.Infof("Hosts cache key %s is invalidated", key)
+			}
+		}
+		if invalidated == 0 {
+			http.Error(w, "keys are not found in cache", http.StatusNotFound)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"Invalidated": invalidated})
+}
+
 // ParsingConfigs list parsing configs names
 func ParsingConfigs(s ServerContext, w http.ResponseWriter, r *http.Request) {
 	list, _ := repository.ListParsingConfigs()
@@ -244,6 +307,11 @@ func GetRouter(context ServerContext) http.Handler {
 	parsingRouter.HandleFunc("/", attachServer(context, ParsingConfigs)).Methods("GET")
 	parsingRouter.HandleFunc("/{name}", attachServer(context, ReadParsingConfig)).Methods("GET")
 
+	cacheRouter := root.PathPrefix("/cache/").Subrouter()
+	cacheRouter.StrictSlash(true)
+	cacheRouter.HandleFunc("/", attachServer(context, CacheEntries)).Methods("GET")
+	cacheRouter.HandleFunc("/", attachServer(context, InvalidateCache)).Methods("DELETE")
+
 	root.HandleFunc("/tasks/{name}", attachServer(context, Tasks)).Methods("GET")
 	root.HandleFunc("/launch/{name}", attachServer(context, Launch)).Methods("GET")
 	root.HandleFunc("/", Dashboard).Methods("GET")
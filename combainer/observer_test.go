@@ -1,9 +1,16 @@
 package combainer
 
 import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/combaine/combaine/common/cache"
 )
 
 func TestRegisterClient(t *testing.T) {
@@ -34,3 +41,49 @@ func TestRegisterClient(t *testing.T) {
 	assert.True(t, len(stats) == 0)
 	assert.True(t, stats["singleConfig"] == nil)
 }
+
+func TestCacheHandlers(t *testing.T) {
+	defer func(c *cache.TTLCache) { combainerCache = c }(combainerCache)
+	combainerCache = cache.NewCache(time.Minute, time.Minute, time.Minute)
+	fetcher := func() ([]string, error) { return []string{"host"}, nil }
+	combainerCache.GetStrings("TestCacheHandlers", "key1", fetcher)
+	combainerCache.GetStrings("TestCacheHandlers", "key1", fetcher)
+	combainerCache.GetStrings("TestCacheHandlers", "consul:http://localhost:8500/", fetcher)
+
+	router := GetRouter(nil)
+	request := func(method, url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, url, nil))
+		return w
+	}
+
+	w := request("GET", "/cache/")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var entries []cache.Entry
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &entries))
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "key1", entries[1].Key)
+	assert.EqualValues(t, 1, entries[1].Hits)
+
+	w = request("GET", "/")
+	var dashboard info
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &dashboard))
+	assert.Equal(t, &cache.Stats{Entries: 2, Hits: 1, Misses: 2}, dashboard.Cache)
+
+	w = request("DELETE", "/cache/?key="+url.QueryEscape("consul:http://localhost:8500/"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"Invalidated": 1}`, w.Body.String())
+	w = request("DELETE", "/cache/?key=unknown")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	for _, u := range []string{"/cache/", "/cache/?key=", "/cache/?all=1&key=key1"} {
+		w = request("DELETE", u)
+		assert.Equal(t, http.StatusBadRequest, w.Code, u)
+	}
+	w = request("DELETE", "/cache/?all=1")
+	assert.JSONEq(t, `{"Invalidated": 2}`, w.Body.String())
+	entries = combainerCache.Entries()
+	assert.Len(t, entries, 2)
+	for _, e := range entries {
+		assert.Equal(t, cache.StateExpired, e.State, e.Key)
+	}
+}
@@ -60,7 +60,10 @@ func (c *Cluster) distributeTasks(hosts []string) error {
 
 	configs, err := repository.ListParsingConfigs()
 	c.log.Debugf("scheduler: Distribute %d configs to %+v", len(configs), hosts)
-	if err != nil {
+	if conflicts, ok := err.(*repository.ConflictError); ok {
+		// conflicting configs are distributed and fail on reading
+		c.log.Errorf("scheduler: %s", conflicts)
+	} else if err != nil {
 		return errors.Wrap(err, "Failed to list parsing config")
 	}
 	configSet := make(map[string]struct{}, len(configs))
@@ -215,7 +218,7 @@ RECLIENT:
 		return
 	default:
 	}
-	cl, err := NewClient()
+	cl, err := NewClient(withSavedCursorsOpt())
 	if err != nil {
 		log.Errorf("scheduler: Can't create client %s, wait %s", err, c.config.RaftUpdateInterval)
 		time.Sleep(c.config.RaftUpdateInterval)
@@ -53,6 +53,14 @@ func New(config CombaineServerConfig) (*CombaineServer, error) {
 	ttl := time.Duration(combainerConfig.MainSection.Cache.TTL) * time.Minute
 	interval := time.Duration(combainerConfig.MainSection.Cache.Interval) * time.Minute
 	combainerCache = cache.NewCache(ttl, interval, interval*10)
+	if maxStale := combainerConfig.MainSection.Cache.MaxStale; maxStale > 0 {
+		combainerCache.SetMaxStale(time.Duration(maxStale) * time.Minute)
+	}
+	if snapshot := combainerConfig.MainSection.Cache.Snapshot; snapshot != "" {
+		if err = combainerCache.LoadSnapshot(snapshot); err != nil && !os.IsNotExist(err) {
+			log.Errorf("Failed to load cache snapshot %s: %s", snapshot, err)
+		}
+	}
 	log.Infof("Initialized combainer cache: %T", combainerCache)
 
 	server := &CombaineServer{
@@ -113,9 +121,31 @@ func (c *CombaineServer) Serve() error {
 	c.log.Info("start task distribution")
 	go c.cluster.Run()
 
+	snapshot := c.CombainerConfig.MainSection.Cache.Snapshot
+	if snapshot != "" {
+		go c.saveCacheSnapshots(snapshot, combainerCache.GetInterval())
+	}
+
 	sigWatcher := make(chan os.Signal, 1)
 	signal.Notify(sigWatcher, os.Interrupt, os.Kill)
 	sig := <-sigWatcher
 	c.log.Info("Got signal:", sig)
+	if snapshot != "" {
+		if err := combainerCache.SaveSnapshot(snapshot); err != nil {
+			c.log.Errorf("Failed to save cache snapshot %s: %s", snapshot, err)
+		}
+	}
 	return nil
 }
+
+// saveCacheSnapshots periodically save discovery cache,
+// so hosts are known after restart during discovery outage
+func (c *CombaineServer) saveCacheSnapshots(path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := combainerCache.SaveSnapshot(path); err != nil {
+			c.log.Errorf("Failed to save cache snapshot %s: %s", path, err)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 type clientStats struct {
 	successParsing   int64
 	failedParsing    int64
+	failedFetch      int64
 	successAggregate int64
 	failedAggregate  int64
 	last             int64
@@ -23,6 +24,12 @@ func (cs *clientStats) AddFailedParsing() {
 	atomic.StoreInt64(&cs.last, time.Now().Unix())
 }
 
+// AddFailedFetch count parsing failed because target was not fetched,
+// it should be called in addition to AddFailedParsing
+func (cs *clientStats) AddFailedFetch() {
+	atomic.AddInt64(&cs.failedFetch, 1)
+}
+
 func (cs *clientStats) AddSuccessAggregate() {
 	atomic.AddInt64(&cs.successAggregate, 1)
 	atomic.StoreInt64(&cs.last, time.Now().Unix())
@@ -42,6 +49,7 @@ func (cs *clientStats) GetStats() *StatInfo {
 		ParsingSuccess:   sPar,
 		ParsingFailed:    fPar,
 		ParsingTotal:     sPar + fPar,
+		FetchFailed:      atomic.LoadInt64(&cs.failedFetch),
 		AggregateSuccess: sAgg,
 		AggregateFailed:  fAgg,
 		AggregateTotal:   sAgg + fAgg,
@@ -52,6 +60,7 @@ func (cs *clientStats) GetStats() *StatInfo {
 func (cs *clientStats) CopyStats(to *clientStats) {
 	atomic.StoreInt64(&to.successParsing, atomic.LoadInt64(&cs.successParsing))
 	atomic.StoreInt64(&to.failedParsing, atomic.LoadInt64(&cs.failedParsing))
+	atomic.StoreInt64(&to.failedFetch, atomic.LoadInt64(&cs.failedFetch))
 	atomic.StoreInt64(&to.successAggregate, atomic.LoadInt64(&cs.successAggregate))
 	atomic.StoreInt64(&to.failedAggregate, atomic.LoadInt64(&cs.failedAggregate))
 }
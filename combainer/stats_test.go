@@ -21,8 +21,11 @@ func TestStat(t *testing.T) {
 	assert.EqualValues(t, c1.successParsing, 1)
 	c1.AddFailedParsing()
 	assert.EqualValues(t, c1.failedParsing, 1)
+	c1.AddFailedFetch()
+	assert.EqualValues(t, c1.failedFetch, 1)
 	stats = c1.GetStats()
 	assert.EqualValues(t, stats.ParsingTotal, 2)
+	assert.EqualValues(t, stats.FetchFailed, 1)
 	wg := &sync.WaitGroup{}
 	for i := 0; i < 10; i++ {
 		wg.Add(1)
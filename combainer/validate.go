@@ -0,0 +1,221 @@
+package combainer
+
+import (
+	"regexp"
+	"sort"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
+
+	"github.com/combaine/combaine/common"
+	"github.com/combaine/combaine/common/hosts"
+	"github.com/combaine/combaine/fetchers"
+	"github.com/combaine/combaine/repository"
+	"github.com/combaine/combaine/senders/graphite"
+	"github.com/combaine/combaine/senders/juggler"
+	"github.com/combaine/combaine/senders/solomon"
+)
+
+// senderConfigs are configs of known senders, sender sections
+// of these types are decoded to check them
+var senderConfigs = map[string]func() interface{}{
+	"juggler":  func() interface{} { return new(juggler.Config) },
+	"graphite": func() interface{} { return new(graphite.Config) },
+	"solomon":  func() interface{} { return new(solomon.Config) },
+}
+
+// ValidateConfigs check parsing and aggregation configs of the initialized
+// repository without connecting to anything, return all found problems
+func ValidateConfigs() []error {
+	var problems []error
+	report := func(prefix string, err error) {
+		problems = append(problems, errors.Wrap(err, prefix))
+	}
+
+	combainerCfg := repository.GetCombainerConfig()
+	if err := repository.VerifyCombainerConfig(&combainerCfg); err != nil {
+		report("combaine.yaml", err)
+	}
+
+	aggregations, err := repository.ListAggregationConfigs()
+	conflicts := listingConflicts("aggregate", err, report)
+	aggregationSet := make(map[string]bool, len(aggregations))
+	for _, name := range aggregations {
+		aggregationSet[name] = true
+		if conflicts[name] {
+			continue
+		}
+		for _, err := range validateAggregationConfig(name) {
+			report("aggregate/"+name, err)
+		}
+	}
+
+	parsings, err := repository.ListParsingConfigs()
+	conflicts = listingConflicts("parsing", err, report)
+	for _, name := range parsings {
+		if conflicts[name] {
+			continue
+		}
+		for _, err := range validateParsingConfig(name, aggregationSet) {
+			report("parsing/"+name, err)
+		}
+	}
+	return problems
+}
+
+// listingConflicts report error of configs listing,
+// return names of configs present in several formats
+func listingConflicts(prefix string, err error, report func(string, error)) map[string]bool {
+	if err == nil {
+		return nil
+	}
+	conflictErr, ok := err.(*repository.ConflictError)
+	if !ok {
+		report(prefix, err)
+		return nil
+	}
+	conflicts := make(map[string]bool, len(conflictErr.Conflicts))
+	for _, name := range conflictErr.Names() {
+		conflicts[name] = true
+		report(prefix+"/"+name, conflictErr.Conflict(name))
+	}
+	return conflicts
+}
+
+func validateParsingConfig(name string, aggregations map[string]bool) []error {
+	encoded, err := repository.GetParsingConfig(name)
+	if err != nil {
+		return []error{err}
+	}
+	var cfg repository.ParsingConfig
+	if err := encoded.Decode(&cfg); err != nil {
+		return []error{errors.Wrap(err, "decode")}
+	}
+	// UpdateByCombainerConfig modifies combainer config,
+	// so it is loaded for each parsing config
+	combainerCfg := repository.GetCombainerConfig()
+	cfg.UpdateByCombainerConfig(&combainerCfg)
+
+	var problems []error
+	aggConfigs := cfg.AggConfigs
+	if len(aggConfigs) == 0 {
+		aggConfigs = []string{name}
+	}
+	for _, agg := range aggConfigs {
+		if !aggregations[agg] {
+			problems = append(problems, errors.Errorf("agg_configs: aggregation config %s does not exist", agg))
+		}
+	}
+
+	if t, err := cfg.DataFetcher.Type(); err != nil {
+		problems = append(problems, errors.Wrap(err, "DataFetcher"))
+	} else if !fetchers.Registered(t) {
+		problems = append(problems, errors.Errorf("DataFetcher: fetcher `%s` isn't registered", t))
+	}
+
+	useDefault := len(cfg.Groups) > 0
+	if cfg.HostsExpr != "" {
+		useDefault = false
+		_, err := hosts.Eval(cfg.HostsExpr, func(group string) (hosts.Hosts, error) {
+			if name, _ := common.SplitHostsGroup(group, cfg.HostFetchers); name == "" {
+				useDefault = true
+			}
+			return hosts.Hosts{}, nil
+		})
+		if err != nil {
+			problems = append(problems, errors.Wrap(err, "hosts_expr"))
+		}
+	}
+	if useDefault {
+		if err := validateHostFetcher(cfg.HostFetcher); err != nil {
+			problems = append(problems, errors.Wrap(err, "HostFetcher"))
+		}
+	}
+	for _, fetcherName := range sortedSections(cfg.HostFetchers) {
+		if err := validateHostFetcher(cfg.HostFetchers[fetcherName]); err != nil {
+			problems = append(problems, errors.Wrapf(err, "HostFetchers.%s", fetcherName))
+		}
+	}
+
+	for _, p := range cfg.IncludeHosts {
+		if _, err := regexp.Compile(p); err != nil {
+			problems = append(problems, errors.Wrap(err, "include_hosts"))
+		}
+	}
+	for _, p := range cfg.ExcludeHosts {
+		if _, err := regexp.Compile(p); err != nil {
+			problems = append(problems, errors.Wrap(err, "exclude_hosts"))
+		}
+	}
+	return problems
+}
+
+func validateHostFetcher(cfg repository.PluginConfig) error {
+	t, err := cfg.Type()
+	if err != nil {
+		return err
+	}
+	if !common.FetcherLoaderRegistered(t) {
+		return errors.Errorf("HostFetcher `%s` isn't registered", t)
+	}
+	return nil
+}
+
+func validateAggregationConfig(name string) []error {
+	encoded, err := repository.GetAggregationConfig(name)
+	if err != nil {
+		return []error{err}
+	}
+	var cfg repository.AggregationConfig
+	if err := encoded.Decode(&cfg); err != nil {
+		return []error{errors.Wrap(err, "decode")}
+	}
+
+	var problems []error
+	for _, section := range sortedSections(cfg.Data) {
+		data := cfg.Data[section]
+		if _, err := data.Type(); err != nil {
+			problems = append(problems, errors.Wrapf(err, "data.%s", section))
+		}
+		if _, err := data.Class(); err != nil {
+			problems = append(problems, errors.Wrapf(err, "data.%s", section))
+		}
+	}
+	for _, section := range sortedSections(cfg.Senders) {
+		sender := cfg.Senders[section]
+		t, err := sender.Type()
+		if err != nil {
+			problems = append(problems, errors.Wrapf(err, "senders.%s", section))
+			continue
+		}
+		newConfig, ok := senderConfigs[t]
+		if !ok {
+			continue
+		}
+		if err := decodeSenderConfig(sender, newConfig()); err != nil {
+			problems = append(problems, errors.Wrapf(err, "senders.%s", section))
+		}
+	}
+	return problems
+}
+
+// decodeSenderConfig decode sender section by codec tags of the sender config
+func decodeSenderConfig(cfg repository.PluginConfig, result interface{}) error {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		TagName: "codec",
+		Result:  result,
+	})
+	if err != nil {
+		return err
+	}
+	return decoder.Decode(map[string]interface{}(cfg))
+}
+
+func sortedSections(sections map[string]repository.PluginConfig) []string {
+	names := make([]string, 0, len(sections))
+	for name := range sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,72 @@
+package combainer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/combaine/combaine/repository"
+)
+
+func TestValidateConfigs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "combaine-validate")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer repository.Init(repoPath)
+
+	files := map[string]string{
+		"combaine.yaml": `
+Combainer: {Main: {MINIMUM_PERIOD: 60}}
+cloud_config:
+  DataFetcher: {type: http}
+  HostFetcher: {type: predefine}
+`,
+		"parsing/good.yaml": "groups: [front]\n",
+		"parsing/json.json": `{"agg_configs": ["good", "goood"], "hosts_expr": "front + zk:back - other:maint - (",
+"HostFetchers": {"zk": {"type": "zookeeper"}}, "DataFetcher": {"type": "unknown"}, "exclude_hosts": ["("]}`,
+		"parsing/broken.yaml":   "groups: {front}\n",
+		"parsing/dup.yaml":      "groups: [front]\n",
+		"parsing/dup.yml":       "groups: [front]\n",
+		"aggregate/good.yaml":   "data:\n  total: {type: custom, class: Multimetrics}\nsenders:\n  graphite: {type: graphite, cluster: front}\n",
+		"aggregate/broken.yaml": "data: [1]\n",
+		"aggregate/bad.yaml": `
+data:
+  total: {type: custom}
+  rps: {class: Multimetrics}
+senders:
+  typeless: {cluster: front}
+  monitoring: {type: juggler, checkname: [a, b]}
+  solomon: {type: solomon, timeout: 5}
+  other: {type: agave, items: 1}
+`,
+	}
+	for name, content := range files {
+		fpath := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(fpath), 0755))
+		assert.NoError(t, ioutil.WriteFile(fpath, []byte(content), 0644))
+	}
+	assert.NoError(t, repository.Init(dir))
+
+	var problems []string
+	for _, err := range ValidateConfigs() {
+		problems = append(problems, err.Error())
+	}
+	expected := []string{
+		"aggregate/bad: data.rps: Missing `type` value",
+		"aggregate/bad: data.total: Missing `class` value",
+		"aggregate/bad: senders.monitoring: 1 error(s) decoding:\n\n* 'checkname' expected type 'string', got unconvertible type '[]interface {}'",
+		"aggregate/bad: senders.typeless: Missing `type` value",
+		"aggregate/broken: decode: yaml: unmarshal errors:\n  line 1: cannot unmarshal !!seq into map[string]repository.PluginConfig",
+		"parsing/dup: conflicting configs dup: " + dir + "/parsing/dup.yaml, " + dir + "/parsing/dup.yml",
+		"parsing/broken: decode: yaml: unmarshal errors:\n  line 1: cannot unmarshal !!map into []string",
+		"parsing/json: agg_configs: aggregation config goood does not exist",
+		"parsing/json: DataFetcher: fetcher `unknown` isn't registered",
+		"parsing/json: hosts_expr: unexpected end of hosts expression",
+		"parsing/json: HostFetchers.zk: HostFetcher `zookeeper` isn't registered",
+		"parsing/json: exclude_hosts: error parsing regexp: missing closing ): `(`",
+	}
+	assert.Equal(t, expected, problems)
+}
@@ -8,12 +8,22 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// staleRetryInterval is delay before the next fetch of the key
+// after failed fetch when stale value is served
+var staleRetryInterval = 10 * time.Second
+
 // item holds cached item
 type itemType struct {
 	expires time.Time
-	value   interface{}
-	err     error
-	ready   chan struct{}
+	// fetched is time when value was successfully fetched
+	fetched time.Time
+	// stale is set when value is served instead of failed fetch
+	stale bool
+	value interface{}
+	err   error
+	ready chan struct{}
+	// refreshing is set while expired value is updated in background
+	refreshing bool
 }
 
 // TTLCache is ttl cache for http responses
@@ -22,8 +32,17 @@ type TTLCache struct {
 	ttl          time.Duration
 	interval     time.Duration
 	cleanupAfter time.Duration
-	store        map[string]*itemType
-	runCleaner   sync.Once
+	// maxStale is max age of the last successfully fetched value
+	// served when fetch fails, zero disables fallback to stale values
+	maxStale time.Duration
+	store    map[string]*itemType
+	// lastGood keeps the last successfully fetched values,
+	// they are served when fetch fails even after removing from the store
+	lastGood map[string]*itemType
+	// counters are access statistics of keys
+	counters   map[string]*keyCounters
+	totals     keyCounters
+	runCleaner sync.Once
 }
 
 // NewCache create new TTLCache instance
@@ -33,11 +52,21 @@ func NewCache(ttl time.Duration, interval time.Duration, cleanupAfter time.Durat
 		interval:     interval,
 		cleanupAfter: cleanupAfter,
 		store:        make(map[string]*itemType),
+		lastGood:     make(map[string]*itemType),
+		counters:     make(map[string]*keyCounters),
 	}
 	go c.cleaner()
 	return c
 }
 
+// SetMaxStale set max age of the last successfully fetched value,
+// which is served when fetch fails
+func (c *TTLCache) SetMaxStale(maxStale time.Duration) {
+	c.Lock()
+	c.maxStale = maxStale
+	c.Unlock()
+}
+
 // TuneCache tune TTLCache ttl and interval
 func (c *TTLCache) TuneCache(ttl time.Duration, interval time.Duration, cleanupAfter time.Duration) {
 	c.Lock()
@@ -47,39 +76,88 @@ func (c *TTLCache) TuneCache(ttl time.Duration, interval time.Duration, cleanupA
 	c.Unlock()
 }
 
-type fetcher func() (interface{}, error)
+// fetcher is called with refresh set when expired entry is updated in background
+type fetcher func(refresh bool) (interface{}, error)
 type bytesFetcher func() ([]byte, error)
 type stringsFetcher func() ([]string, error)
 type mapStringStringsFetcher func() (map[string][]string, error)
+type labeledHostsFetcher func() (map[string][]string, map[string]map[string]string, error)
+type refreshLabeledHostsFetcher func(refresh bool) (map[string][]string, map[string]map[string]string, error)
 
-// Get return not expired element from cacahe or nil
+// labeledHosts is cached result of labeledHostsFetcher
+type labeledHosts struct {
+	hosts  map[string][]string
+	labels map[string]map[string]string
+}
+
+// Get return not expired element from cacahe or nil,
+// if fetch fails the last fetched value not older than maxStale is returned
+// and warning is logged each time the stale value is served
 func (c *TTLCache) get(id string, key string, f fetcher) (interface{}, error) {
 	c.Lock()
 	item := c.store[key]
+	counters := c.keyCounters(key)
 	if item == nil {
+		counters.misses++
+		c.totals.misses++
 		item = &itemType{
 			ready:   make(chan struct{}),
 			expires: time.Now().Add(c.ttl),
 		}
 		c.store[key] = item
 		c.Unlock()
-		item.value, item.err = f()
+		item.value, item.err = f(false)
+		c.Lock()
 		if item.err != nil {
-			c.Lock()
+			c.countError(counters, item.err)
+		}
+		if item.err == nil {
+			item.fetched = time.Now()
+			c.saveLastGood(key, item)
+		} else if last := c.lastGoodItem(key); last != nil {
+			logrus.Warnf("%s Use stale entry for %s fetched at %s: %s",
+				id, key, last.fetched.Format(time.RFC3339), item.err)
+			item.value, item.err = last.value, nil
+			item.fetched, item.stale = last.fetched, true
+			// try to update it on access after retry interval
+			item.expires = time.Now().Add(staleRetryInterval)
+		} else {
 			delete(c.store, key)
-			c.Unlock()
 		}
+		c.Unlock()
 		close(item.ready)
 	} else {
+		counters.hits++
+		c.totals.hits++
 		c.Unlock()
-		logrus.Infof("%s Use cached entry for %s", id, key)
+		<-item.ready
+		if item.stale {
+			logrus.Warnf("%s Use stale cached entry for %s fetched at %s",
+				id, key, item.fetched.Format(time.RFC3339))
+		} else {
+			logrus.Infof("%s Use cached entry for %s", id, key)
+		}
 	}
 	<-item.ready
-	if time.Since(item.expires) > 0 {
+	c.Lock()
+	refresh := time.Since(item.expires) > 0 && !item.refreshing
+	item.refreshing = item.refreshing || refresh
+	c.Unlock()
+	if refresh {
 		go func() {
-			value, err := f()
+			value, err := f(true)
 			if err != nil {
 				logrus.Debugf("%s Failed to update stale cached entry for %s: %s", id, key, err)
+				c.Lock()
+				item.refreshing = false
+				item.expires = time.Now().Add(staleRetryInterval)
+				c.countError(counters, err)
+				if c.maxStale > 0 && time.Since(item.fetched) > c.maxStale && c.store[key] == item {
+					logrus.Warnf("%s Drop stale cached entry for %s fetched at %s",
+						id, key, item.fetched.Format(time.RFC3339))
+					delete(c.store, key)
+				}
+				c.Unlock()
 				return
 			}
 			logrus.Debugf("%s Update stale cached entry for %s", id, key)
@@ -88,9 +166,11 @@ func (c *TTLCache) get(id string, key string, f fetcher) (interface{}, error) {
 				err:     err,
 				ready:   make(chan struct{}),
 				expires: time.Now().Add(c.ttl),
+				fetched: time.Now(),
 			}
 			c.Lock()
 			c.store[key] = updated
+			c.saveLastGood(key, updated)
 			c.Unlock()
 			close(updated.ready)
 		}()
@@ -98,9 +178,30 @@ func (c *TTLCache) get(id string, key string, f fetcher) (interface{}, error) {
 	return item.value, item.err
 }
 
+// saveLastGood keep successfully fetched item to serve it when fetch fails,
+// the cache must be locked
+func (c *TTLCache) saveLastGood(key string, item *itemType) {
+	if c.maxStale > 0 {
+		c.lastGood[key] = item
+	}
+}
+
+// lastGoodItem return the last fetched value not older than maxStale,
+// the cache must be locked
+func (c *TTLCache) lastGoodItem(key string) *itemType {
+	if c.maxStale <= 0 {
+		return nil
+	}
+	last, ok := c.lastGood[key]
+	if !ok || time.Since(last.fetched) > c.maxStale {
+		return nil
+	}
+	return last
+}
+
 // GetBytes from cache
 func (c *TTLCache) GetBytes(id string, key string, f bytesFetcher) ([]byte, error) {
-	rawData, err := c.get(id, key, func() (interface{}, error) { return f() })
+	rawData, err := c.get(id, key, func(bool) (interface{}, error) { return f() })
 	if err != nil {
 		return nil, err
 	}
@@ -113,7 +214,7 @@ func (c *TTLCache) GetBytes(id string, key string, f bytesFetcher) ([]byte, erro
 
 // GetStrings from cache
 func (c *TTLCache) GetStrings(id string, key string, f stringsFetcher) ([]string, error) {
-	rawData, err := c.get(id, key, func() (interface{}, error) { return f() })
+	rawData, err := c.get(id, key, func(bool) (interface{}, error) { return f() })
 	if err != nil {
 		return nil, err
 	}
@@ -126,7 +227,7 @@ func (c *TTLCache) GetStrings(id string, key string, f stringsFetcher) ([]string
 
 // GetMapStringStrings from cache
 func (c *TTLCache) GetMapStringStrings(id string, key string, f mapStringStringsFetcher) (map[string][]string, error) {
-	rawData, err := c.get(id, key, func() (interface{}, error) { return f() })
+	rawData, err := c.get(id, key, func(bool) (interface{}, error) { return f() })
 	if err != nil {
 		return nil, err
 	}
@@ -137,7 +238,34 @@ func (c *TTLCache) GetMapStringStrings(id string, key string, f mapStringStrings
 	return data, nil
 }
 
-// Delete element in the TTLCache
+// GetLabeledHosts from cache, hosts are returned with their labels
+func (c *TTLCache) GetLabeledHosts(id string, key string, f labeledHostsFetcher) (map[string][]string, map[string]map[string]string, error) {
+	return c.RefreshLabeledHosts(id, key, func(bool) (map[string][]string, map[string]map[string]string, error) {
+		return f()
+	})
+}
+
+// RefreshLabeledHosts from cache like GetLabeledHosts, f is called with refresh
+// set when expired entry is updated in background, so it may wait for changes
+func (c *TTLCache) RefreshLabeledHosts(id string, key string, f refreshLabeledHostsFetcher) (map[string][]string, map[string]map[string]string, error) {
+	rawData, err := c.get(id, key, func(refresh bool) (interface{}, error) {
+		h, l, err := f(refresh)
+		if err != nil {
+			return nil, err
+		}
+		return labeledHosts{hosts: h, labels: l}, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	data, ok := rawData.(labeledHosts)
+	if !ok {
+		return nil, nil, errors.New("data is not labeled hosts")
+	}
+	return data.hosts, data.labels, nil
+}
+
+// Delete element in the TTLCache, the last good value is kept
 func (c *TTLCache) Delete(key string) {
 	c.Lock()
 	delete(c.store, key)
@@ -160,13 +288,35 @@ func (c *TTLCache) cleaner() {
 			}
 		}
 		c.RUnlock()
-		if len(staleItems) > 0 {
-			c.Lock()
-			for _, k := range staleItems {
-				delete(c.store, k)
+		c.Lock()
+		for _, k := range staleItems {
+			item, ok := c.store[k]
+			if !ok {
+				continue
 			}
-			c.Unlock()
+			select {
+			case <-item.ready:
+			default:
+				continue // still fetching
+			}
+			if item.err == nil && !item.stale {
+				c.saveLastGood(k, item)
+			}
+			delete(c.store, k)
 		}
+		for k, item := range c.lastGood {
+			if time.Since(item.fetched) > c.maxStale {
+				delete(c.lastGood, k)
+			}
+		}
+		for k, counters := range c.counters {
+			_, cached := c.store[k]
+			_, archived := c.lastGood[k]
+			if !cached && !archived && time.Since(counters.accessed) > c.cleanupAfter {
+				delete(c.counters, k)
+			}
+		}
+		c.Unlock()
 	}
 }
 
@@ -1,6 +1,11 @@
 package cache
 
 import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -71,3 +76,222 @@ func TestCacheWithCleanupInterval(t *testing.T) {
 	assert.Len(t, myCache.store, 0)
 	myCache.RUnlock()
 }
+
+func TestCacheRefresh(t *testing.T) {
+	myCache := NewCache(time.Millisecond, time.Minute, time.Minute)
+	release := make(chan struct{})
+	var calls, refreshes int32
+	fetch := func(refresh bool) (map[string][]string, map[string]map[string]string, error) {
+		atomic.AddInt32(&calls, 1)
+		if refresh {
+			atomic.AddInt32(&refreshes, 1)
+			<-release
+		}
+		return map[string][]string{"DC1": {"host1"}}, nil, nil
+	}
+
+	id := "TestCacheRefresh"
+	_, _, err := myCache.RefreshLabeledHosts(id, "key", fetch)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, atomic.LoadInt32(&refreshes))
+	time.Sleep(time.Millisecond * 5)
+	// expired entry is refreshed by one background fetch at a time
+	for i := 0; i < 3; i++ {
+		found, _, err := myCache.RefreshLabeledHosts(id, "key", fetch)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"host1"}, found["DC1"])
+	}
+	close(release)
+	time.Sleep(time.Millisecond * 20)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&refreshes))
+	assert.EqualValues(t, 2, atomic.LoadInt32(&calls))
+}
+
+func TestCacheLastGood(t *testing.T) {
+	myCache := NewCache(time.Millisecond, time.Minute, time.Minute)
+	myCache.SetMaxStale(time.Minute)
+	failed := errors.New("discovery is down")
+	bad := func() ([]string, error) { return nil, failed }
+
+	id := "TestCacheLastGood"
+	myCache.GetStrings(id, "key", func() ([]string, error) { return []string{"host1"}, nil })
+	myCache.RLock()
+	assert.Equal(t, []string{"host1"}, myCache.lastGood["key"].value)
+	myCache.RUnlock()
+
+	// background update of expired entry is recorded too
+	time.Sleep(time.Millisecond * 5)
+	resp, err := myCache.GetStrings(id, "key", func() ([]string, error) { return []string{"host2"}, nil })
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"host1"}, resp)
+	time.Sleep(time.Millisecond * 5)
+	myCache.Lock()
+	assert.Equal(t, []string{"host2"}, myCache.lastGood["key"].value)
+	delete(myCache.store, "key")
+	myCache.Unlock()
+
+	resp, err = myCache.GetStrings(id, "key", bad)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"host2"}, resp)
+	assert.Equal(t, 1, myCache.Stats().Stale)
+}
+
+func TestCacheStaleFallback(t *testing.T) {
+	defer func(d time.Duration) { staleRetryInterval = d }(staleRetryInterval)
+	staleRetryInterval = time.Millisecond
+	myCache := NewCache(time.Millisecond, time.Millisecond*5, time.Millisecond)
+	myCache.SetMaxStale(time.Millisecond * 100)
+	failed := errors.New("discovery is down")
+	good := func() ([]string, error) { return []string{"host1"}, nil }
+	bad := func() ([]string, error) { return nil, failed }
+
+	id := "TestCacheStaleFallback"
+	_, err := myCache.GetStrings(id, "unknown", bad)
+	assert.Equal(t, failed, err)
+
+	resp, err := myCache.GetStrings(id, "key", good)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"host1"}, resp)
+	time.Sleep(time.Millisecond * 20) // cleaner removes the entry
+
+	myCache.RLock()
+	assert.Len(t, myCache.store, 0)
+	myCache.RUnlock()
+	resp, err = myCache.GetStrings(id, "key", bad)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"host1"}, resp)
+	myCache.RLock()
+	assert.True(t, myCache.store["key"].stale)
+	myCache.RUnlock()
+
+	time.Sleep(time.Millisecond * 120)
+	myCache.RLock()
+	assert.Len(t, myCache.lastGood, 0)
+	myCache.RUnlock()
+	_, err = myCache.GetStrings(id, "key", bad)
+	assert.Equal(t, failed, err)
+}
+
+func TestCacheStaleRetry(t *testing.T) {
+	myCache := NewCache(time.Minute, time.Minute, time.Minute)
+	myCache.SetMaxStale(time.Minute)
+	var calls int32
+	bad := func() ([]string, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, errors.New("discovery is down")
+	}
+
+	id := "TestCacheStaleRetry"
+	myCache.GetStrings(id, "key", func() ([]string, error) { return []string{"host1"}, nil })
+	myCache.Delete("key")
+	for i := 0; i < 5; i++ {
+		resp, err := myCache.GetStrings(id, "key", bad)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"host1"}, resp)
+	}
+	time.Sleep(time.Millisecond * 5)
+	// stale value is not fetched again until retry interval passes
+	assert.EqualValues(t, 1, atomic.LoadInt32(&calls))
+}
+
+func TestCacheSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "combaine-cache")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "snapshot", "cache.json")
+
+	id := "TestCacheSnapshot"
+	src := NewCache(time.Minute, time.Minute, time.Minute)
+	src.GetBytes(id, "bytes", func() ([]byte, error) { return []byte("body"), nil })
+	src.GetStrings(id, "strings", func() ([]string, error) { return []string{"a", "b"}, nil })
+	src.GetMapStringStrings(id, "hosts", func() (map[string][]string, error) {
+		return map[string][]string{"DC1": {"host1"}}, nil
+	})
+	src.GetLabeledHosts(id, "labeled", func() (map[string][]string, map[string]map[string]string, error) {
+		return map[string][]string{"DC1": {"host1"}}, map[string]map[string]string{"host1": {"rack": "r1"}}, nil
+	})
+	src.GetBytes(id, "failed", func() ([]byte, error) { return nil, errors.New("failed") })
+	assert.NoError(t, src.SaveSnapshot(path))
+
+	dst := NewCache(time.Minute, time.Minute, time.Minute)
+	dst.SetMaxStale(time.Hour)
+	assert.NoError(t, dst.LoadSnapshot(path))
+	dst.RLock()
+	assert.Len(t, dst.lastGood, 4)
+	assert.Len(t, dst.store, 0)
+	dst.RUnlock()
+
+	failed := errors.New("discovery is down")
+	b, err := dst.GetBytes(id, "bytes", func() ([]byte, error) { return nil, failed })
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("body"), b)
+	s, err := dst.GetStrings(id, "strings", func() ([]string, error) { return nil, failed })
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, s)
+	h, err := dst.GetMapStringStrings(id, "hosts", func() (map[string][]string, error) { return nil, failed })
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{"DC1": {"host1"}}, h)
+	h, l, err := dst.GetLabeledHosts(id, "labeled", func() (map[string][]string, map[string]map[string]string, error) {
+		return nil, nil, failed
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{"DC1": {"host1"}}, h)
+	assert.Equal(t, map[string]map[string]string{"host1": {"rack": "r1"}}, l)
+
+	assert.Error(t, dst.LoadSnapshot(filepath.Join(dir, "missing")))
+}
+
+func TestCacheEntries(t *testing.T) {
+	myCache := NewCache(time.Minute, time.Minute, time.Minute)
+	myCache.SetMaxStale(time.Minute)
+	failed := errors.New("discovery is down")
+	good := func() ([]string, error) { return []string{"host1"}, nil }
+	bad := func() ([]string, error) { return nil, failed }
+
+	id := "TestCacheEntries"
+	myCache.GetStrings(id, "good", good)
+	myCache.GetStrings(id, "good", good)
+	myCache.GetStrings(id, "good", good)
+	myCache.GetStrings(id, "bad", bad)
+
+	entries := myCache.Entries()
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "bad", entries[0].Key)
+	assert.Equal(t, StateFailed, entries[0].State)
+	assert.EqualValues(t, 1, entries[0].Misses)
+	assert.EqualValues(t, 1, entries[0].Errors)
+	assert.Equal(t, failed.Error(), entries[0].LastError)
+	assert.Equal(t, "good", entries[1].Key)
+	assert.Equal(t, StateValid, entries[1].State)
+	assert.EqualValues(t, 2, entries[1].Hits)
+	assert.EqualValues(t, 1, entries[1].Misses)
+	assert.EqualValues(t, 0, entries[1].Errors)
+	assert.False(t, entries[1].Fetched.IsZero())
+
+	assert.Equal(t, Stats{Entries: 1, Hits: 2, Misses: 2, Errors: 1}, myCache.Stats())
+
+	assert.True(t, myCache.Invalidate("good"))
+	assert.False(t, myCache.Invalidate("unknown"))
+	assert.Equal(t, StateExpired, myCache.Entries()[1].State)
+	// expired value is served while it is fetched again
+	updated := func() ([]string, error) { return []string{"host2"}, nil }
+	resp, err := myCache.GetStrings(id, "good", updated)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"host1"}, resp)
+	for i := 0; i < 100 && resp[0] != "host2"; i++ {
+		time.Sleep(time.Millisecond)
+		resp, err = myCache.GetStrings(id, "good", updated)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, []string{"host2"}, resp)
+
+	// purged value is kept to be served when fetch fails
+	assert.Equal(t, 1, myCache.Purge())
+	entries = myCache.Entries()
+	assert.Len(t, entries, 2)
+	assert.Equal(t, StateExpired, entries[1].State)
+	myCache.Delete("good")
+	resp, err = myCache.GetStrings(id, "good", bad)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"host2"}, resp)
+}
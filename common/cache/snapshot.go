@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// kinds of cached values in snapshot
+const (
+	kindBytes        = "bytes"
+	kindStrings      = "strings"
+	kindHosts        = "hosts"
+	kindLabeledHosts = "labeled_hosts"
+)
+
+// snapshotEntry is successfully fetched value saved on disk
+type snapshotEntry struct {
+	Fetched time.Time                    `json:"fetched"`
+	Kind    string                       `json:"kind"`
+	Bytes   []byte                       `json:"bytes,omitempty"`
+	Strings []string                     `json:"strings,omitempty"`
+	Hosts   map[string][]string          `json:"hosts,omitempty"`
+	Labels  map[string]map[string]string `json:"labels,omitempty"`
+}
+
+func newSnapshotEntry(item *itemType) (snapshotEntry, bool) {
+	e := snapshotEntry{Fetched: item.fetched}
+	switch v := item.value.(type) {
+	case []byte:
+		e.Kind, e.Bytes = kindBytes, v
+	case []string:
+		e.Kind, e.Strings = kindStrings, v
+	case map[string][]string:
+		e.Kind, e.Hosts = kindHosts, v
+	case labeledHosts:
+		e.Kind, e.Hosts, e.Labels = kindLabeledHosts, v.hosts, v.labels
+	default:
+		return e, false
+	}
+	return e, true
+}
+
+func (e *snapshotEntry) value() (interface{}, error) {
+	switch e.Kind {
+	case kindBytes:
+		return e.Bytes, nil
+	case kindStrings:
+		return e.Strings, nil
+	case kindHosts:
+		return e.Hosts, nil
+	case kindLabeledHosts:
+		return labeledHosts{hosts: e.Hosts, labels: e.Labels}, nil
+	}
+	return nil, fmt.Errorf("unknown kind of cached value %q", e.Kind)
+}
+
+// SaveSnapshot save successfully fetched values to the file
+func (c *TTLCache) SaveSnapshot(path string) error {
+	snapshot := make(map[string]snapshotEntry)
+	c.RLock()
+	for key, item := range c.lastGood {
+		if e, ok := newSnapshotEntry(item); ok {
+			snapshot[key] = e
+		}
+	}
+	for key, item := range c.store {
+		select {
+		case <-item.ready:
+		default:
+			continue // still fetching
+		}
+		if item.err != nil {
+			continue
+		}
+		if e, ok := newSnapshotEntry(item); ok {
+			snapshot[key] = e
+		}
+	}
+	c.RUnlock()
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadSnapshot load values saved by SaveSnapshot, loaded values
+// are served only when fetch fails and until they are older than maxStale
+func (c *TTLCache) LoadSnapshot(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var snapshot map[string]snapshotEntry
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return err
+	}
+	c.Lock()
+	defer c.Unlock()
+	for key, e := range snapshot {
+		value, err := e.value()
+		if err != nil {
+			return fmt.Errorf("%s: %s", key, err)
+		}
+		if _, ok := c.store[key]; ok {
+			continue
+		}
+		item := &itemType{value: value, fetched: e.Fetched, ready: make(chan struct{})}
+		close(item.ready)
+		c.lastGood[key] = item
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package cache
+
+import (
+	"sort"
+	"time"
+)
+
+// states of cache entries
+const (
+	// StateFetching entry value is fetching now
+	StateFetching = "fetching"
+	// StateValid entry value is not expired
+	StateValid = "valid"
+	// StateExpired entry value is expired and updated on the next access
+	StateExpired = "expired"
+	// StateStale entry value is served instead of failed fetch
+	StateStale = "stale"
+	// StateLastGood entry value is removed from the cache,
+	// but still can be served when fetch fails
+	StateLastGood = "last_good"
+	// StateFailed entry is not cached due to failed fetch
+	StateFailed = "failed"
+)
+
+// keyCounters are access statistics of the key
+type keyCounters struct {
+	hits          int64
+	misses        int64
+	errors        int64
+	lastError     string
+	lastErrorTime time.Time
+	accessed      time.Time
+}
+
+// keyCounters return counters of the key, the cache must be locked
+func (c *TTLCache) keyCounters(key string) *keyCounters {
+	counters, ok := c.counters[key]
+	if !ok {
+		counters = new(keyCounters)
+		c.counters[key] = counters
+	}
+	counters.accessed = time.Now()
+	return counters
+}
+
+// countError save fetch error of the key, the cache must be locked
+func (c *TTLCache) countError(counters *keyCounters, err error) {
+	counters.errors++
+	counters.lastError = err.Error()
+	counters.lastErrorTime = time.Now()
+	c.totals.errors++
+}
+
+// Entry describe cached key
+type Entry struct {
+	Key   string
+	State string
+	// Fetched is time when the value was successfully fetched
+	Fetched time.Time
+	Expires time.Time
+	// Age is seconds since the value was fetched
+	Age           int64
+	Hits          int64
+	Misses        int64
+	Errors        int64
+	LastError     string
+	LastErrorTime time.Time
+}
+
+// Stats are totals of cache counters,
+// LastGood counts values removed from the store which still can be served
+type Stats struct {
+	Entries  int
+	Stale    int
+	LastGood int
+	Hits     int64
+	Misses   int64
+	Errors   int64
+}
+
+// Entries return description of cached keys sorted by key
+func (c *TTLCache) Entries() []Entry {
+	c.RLock()
+	defer c.RUnlock()
+
+	entries := make(map[string]*Entry)
+	entry := func(key string) *Entry {
+		e, ok := entries[key]
+		if !ok {
+			e = &Entry{Key: key, State: StateFailed}
+			if counters, ok := c.counters[key]; ok {
+				e.Hits, e.Misses, e.Errors = counters.hits, counters.misses, counters.errors
+				e.LastError, e.LastErrorTime = counters.lastError, counters.lastErrorTime
+			}
+			entries[key] = e
+		}
+		return e
+	}
+	for key := range c.counters {
+		entry(key)
+	}
+	for key, item := range c.lastGood {
+		e := entry(key)
+		e.State, e.Fetched = StateLastGood, item.fetched
+	}
+	for key, item := range c.store {
+		e := entry(key)
+		e.Expires = item.expires
+		select {
+		case <-item.ready:
+		default:
+			e.State = StateFetching
+			continue
+		}
+		e.Fetched = item.fetched
+		switch {
+		case item.stale:
+			e.State = StateStale
+		case time.Since(item.expires) > 0:
+			e.State = StateExpired
+		default:
+			e.State = StateValid
+		}
+	}
+
+	result := make([]Entry, 0, len(entries))
+	for _, e := range entries {
+		if !e.Fetched.IsZero() {
+			e.Age = int64(time.Since(e.Fetched) / time.Second)
+		}
+		result = append(result, *e)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result
+}
+
+// Stats return totals of cache counters
+func (c *TTLCache) Stats() Stats {
+	c.RLock()
+	defer c.RUnlock()
+	stats := Stats{
+		Entries: len(c.store),
+		Hits:    c.totals.hits,
+		Misses:  c.totals.misses,
+		Errors:  c.totals.errors,
+	}
+	for _, item := range c.store {
+		if item.stale {
+			stats.Stale++
+		}
+	}
+	for key := range c.lastGood {
+		if _, cached := c.store[key]; !cached {
+			stats.LastGood++
+		}
+	}
+	return stats
+}
+
+// Invalidate mark the key expired, so its value is fetched again
+// on the next access, the last good value is kept to be served if the fetch
+// fails, return false if key is unknown
+func (c *TTLCache) Invalidate(key string) bool {
+	c.Lock()
+	defer c.Unlock()
+	item, cached := c.store[key]
+	if cached {
+		item.expires = time.Now()
+	}
+	_, archived := c.lastGood[key]
+	_, counted := c.counters[key]
+	return cached || archived || counted
+}
+
+// Purge mark all keys expired like Invalidate, return number of expired keys
+func (c *TTLCache) Purge() int {
+	c.Lock()
+	defer c.Unlock()
+	now := time.Now()
+	for _, item := range c.store {
+		item.expires = now
+	}
+	return len(c.store)
+}
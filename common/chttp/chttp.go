@@ -12,10 +12,15 @@ const defaultUserAgent = "Combaine (github.com/combaine)"
 
 // Do general http request with Context
 func Do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	return DoWithClient(ctx, http.DefaultClient, req)
+}
+
+// DoWithClient general http request with Context via the given client
+func DoWithClient(ctx context.Context, client *http.Client, req *http.Request) (*http.Response, error) {
 	if _, ok := req.Header["User-Agent"]; !ok {
 		req.Header.Set("User-Agent", defaultUserAgent)
 	}
-	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	resp, err := client.Do(req.WithContext(ctx))
 	if err != nil {
 		select {
 		case <-ctx.Done():
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -91,3 +92,38 @@ func TestPost(t *testing.T) {
 	assert.Equal(t, text, []byte("PostData"))
 	cancel()
 }
+
+func TestCachedClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chttp")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cert := filepath.Join(dir, "cert.pem")
+	assert.NoError(t, ioutil.WriteFile(cert, []byte("cert"), 0644))
+
+	builds := 0
+	build := func() (*http.Client, error) {
+		builds++
+		return &http.Client{Transport: &http.Transport{}}, nil
+	}
+	c1, err := CachedClient("key", []string{cert, ""}, build)
+	assert.NoError(t, err)
+	c2, err := CachedClient("key", []string{cert, ""}, build)
+	assert.NoError(t, err)
+	assert.True(t, c1 == c2)
+	assert.Equal(t, 1, builds)
+
+	// rotated certificate is reloaded
+	assert.NoError(t, ioutil.WriteFile(cert, []byte("new cert"), 0644))
+	c3, err := CachedClient("key", []string{cert, ""}, build)
+	assert.NoError(t, err)
+	assert.False(t, c1 == c3)
+	assert.Equal(t, 2, builds)
+
+	_, err = CachedClient("other", nil, func() (*http.Client, error) { return nil, fmt.Errorf("bad") })
+	assert.Error(t, err)
+	// removed file is a change too
+	assert.NoError(t, os.Remove(cert))
+	_, err = CachedClient("key", []string{cert}, build)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, builds)
+}
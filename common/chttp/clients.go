@@ -0,0 +1,61 @@
+package chttp
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// cachedClient is client built for the key with stamp of its files
+type cachedClient struct {
+	client *http.Client
+	stamp  string
+}
+
+// clients are shared between fetchers with the same options,
+// fetchers are created for each task, so keep connections reusable
+var clients = struct {
+	sync.Mutex
+	m map[string]*cachedClient
+}{m: make(map[string]*cachedClient)}
+
+// CachedClient return client built by build for the key, the client is
+// rebuilt when any of files is modified, so rotated certificates are reloaded
+func CachedClient(key string, files []string, build func() (*http.Client, error)) (*http.Client, error) {
+	stamp := FilesStamp(files)
+	clients.Lock()
+	defer clients.Unlock()
+	cached, ok := clients.m[key]
+	if ok && cached.stamp == stamp {
+		return cached.client, nil
+	}
+	client, err := build()
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		cached.client.CloseIdleConnections()
+	}
+	clients.m[key] = &cachedClient{client: client, stamp: stamp}
+	return client, nil
+}
+
+// FilesStamp return modification times and sizes of files,
+// it is changed when any of files is modified
+func FilesStamp(files []string) string {
+	stamps := make([]string, 0, len(files))
+	for _, f := range files {
+		if f == "" {
+			continue
+		}
+		info, err := os.Stat(f)
+		if err != nil {
+			stamps = append(stamps, f+":missing")
+			continue
+		}
+		stamps = append(stamps, fmt.Sprintf("%s:%d:%d", f, info.ModTime().UnixNano(), info.Size()))
+	}
+	return strings.Join(stamps, "|")
+}
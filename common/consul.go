@@ -0,0 +1,276 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/combaine/combaine/common/cache"
+	"github.com/combaine/combaine/common/chttp"
+	"github.com/combaine/combaine/common/hosts"
+	"github.com/combaine/combaine/repository"
+)
+
+// ConsulFetcher resolve the group name as consul service to its instances
+type ConsulFetcher struct {
+	cache *cache.TTLCache
+	// Address of consul http api
+	Address string `mapstructure:"address"`
+	Token   string `mapstructure:"token"`
+	// Datacenters to query, datacenter of the agent by default
+	Datacenters []string `mapstructure:"datacenters"`
+	// Tag filters service instances
+	Tag string `mapstructure:"tag"`
+	// Catalog lists all instances from catalog api instead of healthy ones
+	Catalog bool `mapstructure:"catalog"`
+	// DCMetaKey is node meta key mapped to DC, the node datacenter by default
+	DCMetaKey string `mapstructure:"dc_meta_key"`
+	// HostField is node (default), address or service_address
+	HostField   string `mapstructure:"host_field"`
+	ReadTimeout int64
+	// Wait in seconds for blocking queries,
+	// they are used only for refreshing cached entries in background
+	Wait int64 `mapstructure:"wait"`
+}
+
+// consulIndexes are X-Consul-Index of the last answers keyed by service url,
+// fetchers are created for each iteration, so indexes are kept here
+var consulIndexes = struct {
+	sync.Mutex
+	m map[string]uint64
+}{m: make(map[string]uint64)}
+
+// newConsulFetcher return consul catalog hosts fetcher
+func newConsulFetcher(config repository.PluginConfig) (HostFetcher, error) {
+	var fetcher ConsulFetcher
+	if err := mapstructure.Decode(config, &fetcher); err != nil {
+		return nil, err
+	}
+	if fetcher.Address == "" {
+		fetcher.Address = "http://127.0.0.1:8500"
+	}
+	fetcher.Address = strings.TrimRight(fetcher.Address, "/")
+	if fetcher.ReadTimeout <= 0 {
+		fetcher.ReadTimeout = 10
+	}
+	switch fetcher.HostField {
+	case "":
+		fetcher.HostField = "node"
+	case "node", "address", "service_address":
+	default:
+		return nil, fmt.Errorf("consul: unknown host_field %q", fetcher.HostField)
+	}
+	if len(fetcher.Datacenters) == 0 {
+		fetcher.Datacenters = []string{""}
+	}
+	return &fetcher, nil
+}
+
+// consulInstance is the common part of health and catalog api responses
+type consulInstance struct {
+	Node           string
+	Address        string
+	Datacenter     string
+	NodeMeta       map[string]string
+	ServiceAddress string
+	ServiceMeta    map[string]string
+}
+
+type consulHealthEntry struct {
+	Node struct {
+		Node       string
+		Address    string
+		Datacenter string
+		Meta       map[string]string
+	}
+	Service struct {
+		Address string
+		Meta    map[string]string
+	}
+}
+
+// Fetch resolve the service in the list of hosts
+func (c *ConsulFetcher) Fetch(service string) (hosts.Hosts, error) {
+	response, _, err := c.FetchLabeled(service)
+	return response, err
+}
+
+// FetchLabeled resolve the service in the list of hosts,
+// hosts are labeled with node meta and service meta
+func (c *ConsulFetcher) FetchLabeled(service string) (hosts.Hosts, hosts.Labels, error) {
+	log := logrus.WithField("source", "ConsulFetcher")
+
+	response := make(hosts.Hosts)
+	labels := make(hosts.Labels)
+	for _, dc := range c.Datacenters {
+		apiURL := c.serviceURL(service, dc)
+		fetcher := func(refresh bool) (map[string][]string, map[string]map[string]string, error) {
+			instances, err := c.query(apiURL, refresh)
+			if err != nil {
+				log.Errorf("Unable to fetch hosts from %s: %s", apiURL, err)
+				return nil, nil, err
+			}
+			found, foundLabels := c.hosts(instances)
+			return found, foundLabels, nil
+		}
+		var (
+			found       hosts.Hosts
+			foundLabels hosts.Labels
+			err         error
+		)
+		if c.cache != nil {
+			found, foundLabels, err = c.cache.RefreshLabeledHosts(service, c.cacheKey(apiURL), fetcher)
+		} else {
+			found, foundLabels, err = fetcher(false)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		response.Merge(&found)
+		labels.Merge(foundLabels)
+	}
+	if len(response) == 0 {
+		return response, labels, ErrNoHosts
+	}
+	return response, labels, nil
+}
+
+func (c *ConsulFetcher) serviceURL(service, dc string) string {
+	api := "health"
+	params := url.Values{}
+	if c.Catalog {
+		api = "catalog"
+	} else {
+		params.Set("passing", "1")
+	}
+	if dc != "" {
+		params.Set("dc", dc)
+	}
+	if c.Tag != "" {
+		params.Set("tag", c.Tag)
+	}
+	return fmt.Sprintf("%s/v1/%s/service/%s?%s", c.Address, api, url.PathEscape(service), params.Encode())
+}
+
+// cacheKey identify cached hosts of the service url, it includes
+// options used for mapping instances to hosts and datacenters
+func (c *ConsulFetcher) cacheKey(apiURL string) string {
+	return fmt.Sprintf("%s|host_field=%s|dc_meta_key=%s", apiURL, c.HostField, c.DCMetaKey)
+}
+
+// query consul api, blocking query is made if the previous index is known
+func (c *ConsulFetcher) query(apiURL string, blocking bool) ([]consulInstance, error) {
+	timeout := time.Duration(c.ReadTimeout) * time.Second
+	reqURL := apiURL
+	consulIndexes.Lock()
+	index, ok := consulIndexes.m[apiURL]
+	consulIndexes.Unlock()
+	if blocking && ok && c.Wait > 0 {
+		reqURL = fmt.Sprintf("%s&index=%d&wait=%ds", apiURL, index, c.Wait)
+		timeout += time.Duration(c.Wait) * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequest("GET", reqURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.Token != "" {
+		req.Header.Set("X-Consul-Token", c.Token)
+	}
+	resp, err := chttp.Do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("%s answered with %s", apiURL, resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var instances []consulInstance
+	if c.Catalog {
+		err = json.Unmarshal(body, &instances)
+	} else {
+		var entries []consulHealthEntry
+		err = json.Unmarshal(body, &entries)
+		for _, e := range entries {
+			instances = append(instances, consulInstance{
+				Node:           e.Node.Node,
+				Address:        e.Node.Address,
+				Datacenter:     e.Node.Datacenter,
+				NodeMeta:       e.Node.Meta,
+				ServiceAddress: e.Service.Address,
+				ServiceMeta:    e.Service.Meta,
+			})
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse json body: %s", err)
+	}
+
+	if newIndex, err := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64); err == nil {
+		consulIndexes.Lock()
+		consulIndexes.m[apiURL] = newIndex
+		consulIndexes.Unlock()
+	}
+	return instances, nil
+}
+
+func (c *ConsulFetcher) hosts(instances []consulInstance) (map[string][]string, map[string]map[string]string) {
+	parsed := make(map[string][]string)
+	labels := make(map[string]map[string]string)
+	for _, i := range instances {
+		host := i.Node
+		switch c.HostField {
+		case "address":
+			host = i.Address
+		case "service_address":
+			host = i.ServiceAddress
+			if host == "" {
+				host = i.Address
+			}
+		}
+		if host == "" {
+			continue
+		}
+		dc := i.Datacenter
+		if c.DCMetaKey != "" {
+			dc = i.NodeMeta[c.DCMetaKey]
+		}
+		if dc == "" {
+			dc = "NoDC"
+		}
+		parsed[dc] = append(parsed[dc], host)
+		if len(i.NodeMeta)+len(i.ServiceMeta) > 0 {
+			hostLabels := make(map[string]string, len(i.NodeMeta)+len(i.ServiceMeta))
+			for k, v := range i.NodeMeta {
+				hostLabels[k] = v
+			}
+			for k, v := range i.ServiceMeta {
+				hostLabels[k] = v
+			}
+			labels[host] = hostLabels
+		}
+	}
+	return parsed, labels
+}
+
+func (c *ConsulFetcher) setCache(cache *cache.TTLCache) {
+	c.cache = cache
+}
@@ -0,0 +1,144 @@
+package common
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/combaine/combaine/common/cache"
+	"github.com/combaine/combaine/common/hosts"
+	"github.com/combaine/combaine/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsulFetcher(t *testing.T) {
+	var blocking int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Consul-Token") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		q := r.URL.Query()
+		if q.Get("index") != "" {
+			atomic.AddInt32(&blocking, 1)
+			assert.Equal(t, "42", q.Get("index"))
+			assert.Equal(t, "1s", q.Get("wait"))
+		}
+		w.Header().Set("X-Consul-Index", "42")
+		switch r.URL.Path {
+		case "/v1/health/service/front":
+			assert.Equal(t, "1", q.Get("passing"))
+			assert.Equal(t, "web", q.Get("tag"))
+			fmt.Fprintf(w, `[
+{"Node": {"Node": "front1%[1]s", "Address": "10.0.0.1", "Datacenter": "%[1]s", "Meta": {"zone": "zone-a"}},
+ "Service": {"Address": "10.1.0.1", "Meta": {"shard": "1"}}},
+{"Node": {"Node": "front2%[1]s", "Address": "10.0.0.2", "Datacenter": "%[1]s", "Meta": {}},
+ "Service": {"Address": ""}}]`, q.Get("dc"))
+		case "/v1/catalog/service/front":
+			fmt.Fprint(w, `[{"Node": "front1", "Address": "10.0.0.1", "Datacenter": "dc1",
+"NodeMeta": {"zone": "zone-a"}, "ServiceAddress": "10.1.0.1"}]`)
+		case "/v1/health/service/empty":
+			fmt.Fprint(w, `[]`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	cases := []struct {
+		config   repository.PluginConfig
+		service  string
+		expected hosts.Hosts
+		err      bool
+	}{
+		{repository.PluginConfig{"datacenters": []string{"dc1", "dc2"}},
+			"front", hosts.Hosts{"dc1": {"front1dc1", "front2dc1"}, "dc2": {"front1dc2", "front2dc2"}}, false},
+		{repository.PluginConfig{"dc_meta_key": "zone", "host_field": "service_address"},
+			"front", hosts.Hosts{"zone-a": {"10.1.0.1"}, "NoDC": {"10.0.0.2"}}, false},
+		{repository.PluginConfig{"catalog": true, "host_field": "address"},
+			"front", hosts.Hosts{"dc1": {"10.0.0.1"}}, false},
+		{repository.PluginConfig{}, "empty", nil, true},
+		{repository.PluginConfig{}, "unknown", nil, true},
+		{repository.PluginConfig{"token": "wrong"}, "front", nil, true},
+	}
+	for _, c := range cases {
+		c.config["type"] = "consul"
+		c.config["address"] = ts.URL + "/"
+		if _, ok := c.config["token"]; !ok {
+			c.config["token"] = "secret"
+		}
+		if _, ok := c.config["catalog"]; !ok {
+			c.config["tag"] = "web"
+		}
+		f, err := LoadHostFetcher(c.config)
+		assert.NoError(t, err)
+		found, err := f.Fetch(c.service)
+		if c.err {
+			assert.Error(t, err, c.config)
+			continue
+		}
+		assert.NoError(t, err, c.config)
+		assert.Equal(t, c.expected, found, c.config)
+	}
+
+	_, err := LoadHostFetcher(repository.PluginConfig{"type": "consul", "host_field": "fqdn"})
+	assert.Error(t, err)
+
+	// hosts are labeled with node and service meta
+	lf, err := LoadHostFetcher(repository.PluginConfig{
+		"type": "consul", "address": ts.URL, "token": "secret", "tag": "web", "datacenters": []string{"dc1"},
+	})
+	assert.NoError(t, err)
+	_, labels, err := FetchLabeled(lf, "front")
+	assert.NoError(t, err)
+	assert.Equal(t, hosts.Labels{"front1dc1": {"zone": "zone-a", "shard": "1"}}, labels)
+
+	// blocking queries are used only for background cache refreshing,
+	// fetchers are created for each iteration, so the index is shared
+	blockingConfig := repository.PluginConfig{
+		"type": "consul", "address": ts.URL, "token": "secret", "tag": "web", "wait": 1,
+	}
+	ttlCache := cache.NewCache(time.Millisecond, time.Minute, time.Minute)
+	f, err := LoadHostFetcherWithCache(blockingConfig, ttlCache)
+	assert.NoError(t, err)
+	_, err = f.Fetch("front")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, atomic.LoadInt32(&blocking))
+	time.Sleep(5 * time.Millisecond)
+	f, err = LoadHostFetcherWithCache(blockingConfig, ttlCache)
+	assert.NoError(t, err)
+	found, err := f.Fetch("front")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"front1", "front2"}, found["NoDC"])
+	waitFor(func() bool { return atomic.LoadInt32(&blocking) == 1 })
+	assert.EqualValues(t, 1, atomic.LoadInt32(&blocking))
+
+	// hosts mapped with different options are cached separately
+	shared := cache.NewCache(time.Minute, time.Minute, time.Minute)
+	for _, c := range []struct {
+		config   repository.PluginConfig
+		expected hosts.Hosts
+	}{
+		{repository.PluginConfig{}, hosts.Hosts{"dc1": {"front1dc1", "front2dc1"}}},
+		{repository.PluginConfig{"host_field": "address"}, hosts.Hosts{"dc1": {"10.0.0.1", "10.0.0.2"}}},
+		{repository.PluginConfig{"dc_meta_key": "zone"}, hosts.Hosts{"zone-a": {"front1dc1"}, "NoDC": {"front2dc1"}}},
+	} {
+		c.config["type"], c.config["address"], c.config["token"] = "consul", ts.URL, "secret"
+		c.config["tag"], c.config["datacenters"] = "web", []string{"dc1"}
+		f, err := LoadHostFetcherWithCache(c.config, shared)
+		assert.NoError(t, err)
+		found, err := f.Fetch("front")
+		assert.NoError(t, err)
+		assert.Equal(t, c.expected, found, c.config)
+	}
+
+	// known index does not make synchronous fetch blocking
+	f, err = LoadHostFetcherWithCache(blockingConfig, cache.NewCache(time.Minute, time.Minute, time.Minute))
+	assert.NoError(t, err)
+	_, err = f.Fetch("front")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&blocking))
+}
@@ -6,12 +6,12 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/miekg/dns"
-	"github.com/samuel/go-zookeeper/zk"
 
 	"github.com/combaine/combaine/common/cache"
 	"github.com/combaine/combaine/common/chttp"
@@ -38,6 +38,21 @@ func init() {
 	if err := RegisterFetcherLoader("qloud", newQDNSFetcher); err != nil {
 		panic(err)
 	}
+	if err := RegisterFetcherLoader("consul", newConsulFetcher); err != nil {
+		panic(err)
+	}
+	if err := RegisterFetcherLoader("kubernetes", newKubernetesFetcher); err != nil {
+		panic(err)
+	}
+	if err := RegisterFetcherLoader("file", newFileFetcher); err != nil {
+		panic(err)
+	}
+	if err := RegisterFetcherLoader("dns", newDNSFetcher); err != nil {
+		panic(err)
+	}
+	if err := RegisterFetcherLoader("etcd", newEtcdFetcher); err != nil {
+		panic(err)
+	}
 }
 
 // FetcherLoader is type of function is responsible for loading fetchers
@@ -54,6 +69,12 @@ func RegisterFetcherLoader(name string, f FetcherLoader) error {
 	return nil
 }
 
+// FetcherLoaderRegistered check that hosts fetcher loader is registered
+func FetcherLoaderRegistered(name string) bool {
+	_, ok := fetchers[name]
+	return ok
+}
+
 // LoadHostFetcher create, configure and return new hosts fetcher
 func LoadHostFetcher(config repository.PluginConfig) (HostFetcher, error) {
 	name, err := config.Type()
@@ -91,7 +112,60 @@ type HostFetcher interface {
 	setCache(cache *cache.TTLCache)
 }
 
+// LabeledHostFetcher is HostFetcher which discovers labels of hosts
+type LabeledHostFetcher interface {
+	HostFetcher
+	FetchLabeled(group string) (hosts.Hosts, hosts.Labels, error)
+}
+
+// FetchLabeled return hosts of the group and their labels,
+// labels are empty if the fetcher does not discover them
+func FetchLabeled(f HostFetcher, group string) (hosts.Hosts, hosts.Labels, error) {
+	if lf, ok := f.(LabeledHostFetcher); ok {
+		return lf.FetchLabeled(group)
+	}
+	found, err := f.Fetch(group)
+	return found, make(hosts.Labels), err
+}
+
+// parseHostData parse json object describing the host, it return values
+// of hostField and dcField and other scalar fields as labels,
+// only labels listed in keep are returned if keep is not empty
+func parseHostData(data []byte, hostField, dcField string, keep []string) (string, string, map[string]string, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return "", "", nil, err
+	}
+	host, _ := fields[hostField].(string)
+	dc, _ := fields[dcField].(string)
+	labels := make(map[string]string)
+	for k, v := range fields {
+		if k == hostField || k == dcField {
+			continue
+		}
+		switch t := v.(type) {
+		case string:
+			labels[k] = t
+		case float64:
+			labels[k] = strconv.FormatFloat(t, 'f', -1, 64)
+		case bool:
+			labels[k] = strconv.FormatBool(t)
+		}
+	}
+	if len(keep) > 0 {
+		selected := make(map[string]string, len(keep))
+		for _, k := range keep {
+			if v, ok := labels[k]; ok {
+				selected[k] = v
+			}
+		}
+		labels = selected
+	}
+	return host, dc, labels, nil
+}
+
 // PredefineFetcher is map[string /*datacenter name*/][]string /*list of hosts*/
+// Deprecated: use FileFetcher, it does not require restart on changes
 type PredefineFetcher struct {
 	mutex sync.Mutex
 	PredefineFetcherConfig
@@ -140,6 +214,8 @@ type SimpleFetcher struct {
 	ReadTimeout int64
 	Options     map[string]string
 	BasicURL    string `mapstructure:"BasicUrl"`
+	// Labels are keys of json host description copied to labels of the host
+	Labels []string `mapstructure:"labels"`
 }
 
 // newHTTPFetcher return list of hosts fethed from http discovery service
@@ -171,9 +247,16 @@ func newHTTPFetcher(config repository.PluginConfig) (HostFetcher, error) {
 
 // Fetch resolve the group name in the list of hosts
 func (s *SimpleFetcher) Fetch(groupname string) (hosts.Hosts, error) {
+	response, _, err := s.FetchLabeled(groupname)
+	return response, err
+}
+
+// FetchLabeled resolve the group name in the list of hosts,
+// hosts are labeled only in json format
+func (s *SimpleFetcher) FetchLabeled(groupname string) (hosts.Hosts, hosts.Labels, error) {
 	log := logrus.WithField("source", "SimpleFetcher")
 	if !strings.Contains(s.BasicURL, `%s`) {
-		return nil, ErrMissingFormatSpecifier
+		return nil, nil, ErrMissingFormatSpecifier
 	}
 	url := fmt.Sprintf(s.BasicURL, groupname)
 
@@ -209,7 +292,7 @@ func (s *SimpleFetcher) Fetch(groupname string) (hosts.Hosts, error) {
 		body, err = fetcher()
 	}
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// Body parsing
@@ -217,7 +300,8 @@ func (s *SimpleFetcher) Fetch(groupname string) (hosts.Hosts, error) {
 	case "json":
 		return s.parseJSON(body)
 	default:
-		return s.parseTSV(body)
+		parsed, err := s.parseTSV(body)
+		return parsed, make(hosts.Labels), err
 	}
 }
 func (s *SimpleFetcher) setCache(c *cache.TTLCache) {
@@ -247,15 +331,16 @@ func (s *SimpleFetcher) parseTSV(body []byte) (hosts.Hosts, error) {
 	return parsed, nil
 }
 
-func (s *SimpleFetcher) parseJSON(body []byte) (hosts.Hosts, error) {
+func (s *SimpleFetcher) parseJSON(body []byte) (hosts.Hosts, hosts.Labels, error) {
 	log := logrus.WithField("source", "SimpleFetcher.parseJSON")
 
 	var resp []map[string]string
 	if err := json.Unmarshal(body, &resp); err != nil {
-		return nil, fmt.Errorf("Failed to parse json body: %s", err)
+		return nil, nil, fmt.Errorf("Failed to parse json body: %s", err)
 	}
 
 	parsed := make(hosts.Hosts)
+	labels := make(hosts.Labels)
 	var fqdn string
 	var dc string
 	var ok bool
@@ -269,11 +354,19 @@ func (s *SimpleFetcher) parseJSON(body []byte) (hosts.Hosts, error) {
 			dc = "NoDC"
 		}
 		parsed[dc] = append(parsed[dc], fqdn)
+		for _, key := range s.Labels {
+			if value, ok := dcAndHost[key]; ok && value != "" {
+				if labels[fqdn] == nil {
+					labels[fqdn] = make(map[string]string)
+				}
+				labels[fqdn][key] = value
+			}
+		}
 	}
 	if len(parsed) == 0 {
-		return parsed, ErrNoHosts
+		return parsed, labels, ErrNoHosts
 	}
-	return parsed, nil
+	return parsed, labels, nil
 }
 
 // RTCFetcher recive hosts from RTC groups
@@ -304,12 +397,20 @@ func newRTCFetcher(config repository.PluginConfig) (HostFetcher, error) {
 
 // Fetch resolve the group name in the list of hosts
 func (s *RTCFetcher) Fetch(groupname string) (hosts.Hosts, error) {
+	response, _, err := s.FetchLabeled(groupname)
+	return response, err
+}
+
+// FetchLabeled resolve the group name in the list of hosts,
+// hosts are labeled with the container host
+func (s *RTCFetcher) FetchLabeled(groupname string) (hosts.Hosts, hosts.Labels, error) {
 	log := logrus.WithField("source", "RTCFetcher")
 	if !strings.Contains(s.BasicURL, `%s`) {
-		return nil, ErrMissingFormatSpecifier
+		return nil, nil, ErrMissingFormatSpecifier
 	}
 
 	var response = make(hosts.Hosts)
+	var labels = make(hosts.Labels)
 	for _, geo := range s.Geo {
 		suffix := geo
 		if suffix != "" {
@@ -353,18 +454,19 @@ func (s *RTCFetcher) Fetch(groupname string) (hosts.Hosts, error) {
 			log.Errorf("Cache.Get failed for: %s", urlGeo)
 			continue
 		}
-		hostnames, err := s.parseJSON(body)
+		hostnames, hostLabels, err := s.parseJSON(body)
 		if err != nil {
 			log.Errorf("Failed to parse response: %s", err)
 		}
 		if len(hostnames) != 0 {
 			response[geo] = hostnames
+			labels.Merge(hostLabels)
 		}
 	}
 	if len(response) == 0 {
-		return response, ErrNoHosts
+		return response, labels, ErrNoHosts
 	}
-	return response, nil
+	return response, labels, nil
 }
 func (s *RTCFetcher) setCache(c *cache.TTLCache) {
 	s.cache = c
@@ -375,86 +477,24 @@ type rtcResponse struct {
 }
 type rtcItem struct {
 	Hostname string `json:"container_hostname"`
+	Host     string `json:"host"`
 }
 
-func (s *RTCFetcher) parseJSON(body []byte) ([]string, error) {
+func (s *RTCFetcher) parseJSON(body []byte) ([]string, hosts.Labels, error) {
 	var resp rtcResponse
 	if err := json.Unmarshal(body, &resp); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	hostList := make([]string, len(resp.Items))
+	labels := make(hosts.Labels)
 	for idx, item := range resp.Items {
 		hostList[idx] = item.Hostname
-	}
-	return hostList, nil
-}
-
-// ZKFetcher recive hosts from ZK dir
-type ZKFetcher struct {
-	cache     *cache.TTLCache
-	Servers   []string `mapstructure:"servers"`
-	StripPort bool     `mapstructure:"strip_port"`
-}
-
-// newZKFetcher return list of hosts fetched from zk discovery service
-func newZKFetcher(config repository.PluginConfig) (HostFetcher, error) {
-	var fetcher ZKFetcher
-	if err := mapstructure.Decode(config, &fetcher); err != nil {
-		return nil, err
-	}
-	return &fetcher, nil
-}
-
-// Fetch hosts from zk node
-func (s *ZKFetcher) Fetch(path string) (hosts.Hosts, error) {
-	log := logrus.WithField("source", "ZKFetcher")
-
-	var response = make(hosts.Hosts)
-
-	url := "zk://" + strings.Join(s.Servers, ",") + "/" + path
-	fetcher := func() ([]string, error) {
-
-		conn, _, err := zk.Connect(s.Servers, time.Second*10, zk.WithLogger(log))
-		if err != nil {
-			return nil, err
-		}
-		defer conn.Close()
-
-		list, _, err := conn.Children(path)
-		if err != nil {
-			return nil, err
-		}
-
-		return list, nil
-	}
-
-	var list []string
-	var err error
-	if s.cache != nil {
-		list, err = s.cache.GetStrings(path, url, fetcher)
-	} else {
-		list, err = fetcher()
-	}
-	if err != nil {
-		return nil, err
-	}
-
-	if s.StripPort {
-		for idx, item := range list {
-			delimIdx := strings.LastIndex(item, ":")
-			if delimIdx > -1 {
-				list[idx] = item[:delimIdx]
-			}
+		if item.Host != "" {
+			labels[item.Hostname] = map[string]string{"container_host": item.Host}
 		}
 	}
-
-	response["unk"] = list
-	return response, nil
-}
-
-func (s *ZKFetcher) setCache(c *cache.TTLCache) {
-	s.cache = c
+	return hostList, labels, nil
 }
 
 // QDNSFetcher recive hosts from dns discovery
@@ -522,6 +562,10 @@ func (d *QDNSFetcher) Fetch(entity string) (hosts.Hosts, error) {
 				log.Errorf("%s %s", entity, err)
 				continue
 			}
+			if len(in.Answer) == 0 {
+				log.Errorf("%s empty answer for %s", entity, m.Question[0].Name)
+				continue
+			}
 			if rt, ok := in.Answer[0].(*dns.SRV); ok {
 				dcIdx := strings.IndexFunc(rt.Target, dcIndex)
 				if dcIdx > 0 {
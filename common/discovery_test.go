@@ -76,8 +76,8 @@ func TestHttpFetcher(t *testing.T) {
 			payload := []byte(`[
 				{ "root_datacenter_name": "dcA" },
 				{ "fqdn": "", "root_datacenter_name": "dcA" },
-				{ "fqdn": "host1.in.dcA", "root_datacenter_name": "dcA" },
-				{ "fqdn": "host2.in.dcA", "root_datacenter_name": "dcA" },
+				{ "fqdn": "host1.in.dcA", "root_datacenter_name": "dcA", "rack": "r1" },
+				{ "fqdn": "host2.in.dcA", "root_datacenter_name": "dcA", "rack": "" },
 				{ "fqdn": "host1.in.dcB", "root_datacenter_name": "dcB" },
 				{ "fqdn": "host2.in.dcB", "root_datacenter_name": "dcB" }
 			]`)
@@ -163,6 +163,22 @@ func TestHttpFetcher(t *testing.T) {
 			assert.Equal(t, c.expect, resp)
 		}
 	}
+
+	hFetcher, err := LoadHostFetcher(repository.PluginConfig{
+		"type":     "http",
+		"Format":   "json",
+		"labels":   []string{"rack", "root_datacenter_name"},
+		"BasicUrl": fmt.Sprintf("http://%s/fetch", ts.Listener.Addr()) + "/%s",
+	})
+	assert.NoError(t, err)
+	_, labels, err := FetchLabeled(hFetcher, "group1-json")
+	assert.NoError(t, err)
+	assert.Equal(t, hosts.Labels{
+		"host1.in.dcA": {"rack": "r1", "root_datacenter_name": "dcA"},
+		"host2.in.dcA": {"root_datacenter_name": "dcA"},
+		"host1.in.dcB": {"root_datacenter_name": "dcB"},
+		"host2.in.dcB": {"root_datacenter_name": "dcB"},
+	}, labels)
 }
 
 func TestRTCFetcher(t *testing.T) {
@@ -171,7 +187,7 @@ func TestRTCFetcher(t *testing.T) {
 		case "/fetch/group1-json-rtc_dc1":
 			payload := []byte(`{
 				"result": [
-					{ "container_hostname": "host1.dc1" },
+					{ "container_hostname": "host1.dc1", "host": "node1.dc1" },
 					{ "container_hostname": "host2.dc1" }
 				]
 			}`)
@@ -234,4 +250,10 @@ func TestRTCFetcher(t *testing.T) {
 			assert.Equal(t, c.expect, resp)
 		}
 	}
+
+	hFetcher, err := LoadHostFetcher(cases[0].config)
+	assert.NoError(t, err)
+	_, labels, err := FetchLabeled(hFetcher, "group1-json-rtc")
+	assert.NoError(t, err)
+	assert.Equal(t, hosts.Labels{"host1.dc1": {"container_host": "node1.dc1"}}, labels)
 }
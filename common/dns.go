@@ -0,0 +1,229 @@
+package common
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/combaine/combaine/common/cache"
+	"github.com/combaine/combaine/common/hosts"
+	"github.com/combaine/combaine/repository"
+)
+
+var dnsQueryTypes = map[string]uint16{
+	"A":    dns.TypeA,
+	"AAAA": dns.TypeAAAA,
+	"SRV":  dns.TypeSRV,
+	"TXT":  dns.TypeTXT,
+}
+
+// DNSFetcher resolve the group as dns name, hosts are addresses of A and AAAA
+// records, targets of SRV records or whitespace separated names in TXT records
+type DNSFetcher struct {
+	cache *cache.TTLCache
+	// Resolvers are queried in order until one of them answers,
+	// nameservers from /etc/resolv.conf are used by default
+	Resolvers []string `mapstructure:"resolvers"`
+	// QueryType is A (default), AAAA, SRV or TXT
+	QueryType string `mapstructure:"query_type"`
+	// Net is udp (default) or tcp
+	Net          string `mapstructure:"net"`
+	QueryTimeout int64
+	// DCRegex extracts DC from the host with the first submatch or the whole match
+	DCRegex string `mapstructure:"dc_regex"`
+	// DCMap maps the host, its domain (key starts with dot)
+	// or DC extracted by DCRegex to DC
+	DCMap map[string]string `mapstructure:"dc_map"`
+
+	qtype   uint16
+	dcRegex *regexp.Regexp
+	timeout time.Duration
+}
+
+// resolvConf is used for default resolvers
+var resolvConf = "/etc/resolv.conf"
+
+// newDNSFetcher return dns hosts fetcher
+func newDNSFetcher(config repository.PluginConfig) (HostFetcher, error) {
+	var fetcher DNSFetcher
+	if err := mapstructure.Decode(config, &fetcher); err != nil {
+		return nil, err
+	}
+	if fetcher.QueryType == "" {
+		fetcher.QueryType = "A"
+	}
+	qtype, ok := dnsQueryTypes[strings.ToUpper(fetcher.QueryType)]
+	if !ok {
+		return nil, fmt.Errorf("dns: unsupported query_type %q", fetcher.QueryType)
+	}
+	fetcher.qtype = qtype
+	switch fetcher.Net {
+	case "":
+		fetcher.Net = "udp"
+	case "udp", "tcp":
+	default:
+		return nil, fmt.Errorf("dns: unsupported net %q", fetcher.Net)
+	}
+	if fetcher.DCRegex != "" {
+		var err error
+		if fetcher.dcRegex, err = regexp.Compile(fetcher.DCRegex); err != nil {
+			return nil, errors.Wrap(err, "dns: dc_regex")
+		}
+	}
+	if len(fetcher.Resolvers) == 0 {
+		if conf, err := dns.ClientConfigFromFile(resolvConf); err == nil {
+			for _, s := range conf.Servers {
+				fetcher.Resolvers = append(fetcher.Resolvers, net.JoinHostPort(s, conf.Port))
+			}
+		}
+	}
+	if len(fetcher.Resolvers) == 0 {
+		fetcher.Resolvers = []string{"[::1]:53"}
+	}
+	for idx, r := range fetcher.Resolvers {
+		if _, _, err := net.SplitHostPort(r); err != nil {
+			fetcher.Resolvers[idx] = net.JoinHostPort(r, "53")
+		}
+	}
+	if fetcher.QueryTimeout <= 0 {
+		fetcher.QueryTimeout = 5
+	}
+	fetcher.timeout = time.Duration(fetcher.QueryTimeout) * time.Second
+	return &fetcher, nil
+}
+
+// Fetch resolve the name in the list of hosts,
+// the answer is cached before hosts are mapped to datacenters
+func (d *DNSFetcher) Fetch(name string) (hosts.Hosts, error) {
+	name = dns.Fqdn(name)
+	key := fmt.Sprintf("dns:%s:%s@%s", dns.TypeToString[d.qtype], name, strings.Join(d.Resolvers, ","))
+
+	fetcher := func() ([]string, error) {
+		answer, err := d.exchange(name)
+		if err != nil {
+			return nil, err
+		}
+		found := d.hosts(answer)
+		if len(found) == 0 {
+			return nil, ErrNoHosts
+		}
+		return found, nil
+	}
+
+	var found []string
+	var err error
+	if d.cache != nil {
+		found, err = d.cache.GetStrings(name, key, fetcher)
+	} else {
+		found, err = fetcher()
+	}
+	if err != nil {
+		return nil, err
+	}
+	response := make(hosts.Hosts)
+	for _, host := range found {
+		dc := d.datacenter(host)
+		response[dc] = append(response[dc], host)
+	}
+	return response, nil
+}
+
+// exchange query resolvers in order until the authoritative answer
+func (d *DNSFetcher) exchange(name string) ([]dns.RR, error) {
+	log := logrus.WithField("source", "DNSFetcher")
+
+	m := new(dns.Msg)
+	m.SetQuestion(name, d.qtype)
+	client := dns.Client{Net: d.Net, Timeout: d.timeout}
+
+	var lastErr error
+	for _, resolver := range d.Resolvers {
+		in, _, err := client.Exchange(m, resolver)
+		if err == nil && in.Truncated && client.Net == "udp" {
+			tcp := dns.Client{Net: "tcp", Timeout: d.timeout}
+			in, _, err = tcp.Exchange(m, resolver)
+		}
+		if err != nil {
+			log.Errorf("%s query %s failed: %s", resolver, name, err)
+			lastErr = err
+			continue
+		}
+		switch in.Rcode {
+		case dns.RcodeSuccess:
+			return in.Answer, nil
+		case dns.RcodeNameError:
+			return nil, ErrNoHosts
+		default:
+			lastErr = errors.Errorf("%s answered %s for %s", resolver, dns.RcodeToString[in.Rcode], name)
+			log.Error(lastErr)
+		}
+	}
+	return nil, lastErr
+}
+
+func (d *DNSFetcher) hosts(answer []dns.RR) []string {
+	var found []string
+	for _, rr := range answer {
+		switch r := rr.(type) {
+		case *dns.A:
+			if d.qtype == dns.TypeA {
+				found = append(found, r.A.String())
+			}
+		case *dns.AAAA:
+			if d.qtype == dns.TypeAAAA {
+				found = append(found, r.AAAA.String())
+			}
+		case *dns.SRV:
+			if t := strings.TrimSuffix(r.Target, "."); t != "" {
+				found = append(found, t)
+			}
+		case *dns.TXT:
+			for _, txt := range r.Txt {
+				found = append(found, strings.Fields(txt)...)
+			}
+		}
+	}
+	return found
+}
+
+func (d *DNSFetcher) datacenter(host string) string {
+	if dc, ok := d.DCMap[host]; ok {
+		return dc
+	}
+	if d.dcRegex != nil {
+		if match := d.dcRegex.FindStringSubmatch(host); match != nil {
+			dc := match[0]
+			if len(match) > 1 {
+				dc = match[1]
+			}
+			if mapped, ok := d.DCMap[dc]; ok {
+				return mapped
+			}
+			if dc != "" {
+				return dc
+			}
+		}
+	}
+	for domain := host; ; {
+		idx := strings.Index(domain[1:], ".")
+		if idx < 0 {
+			break
+		}
+		domain = domain[idx+1:]
+		if dc, ok := d.DCMap[domain]; ok {
+			return dc
+		}
+	}
+	return "NoDC"
+}
+
+func (d *DNSFetcher) setCache(c *cache.TTLCache) {
+	d.cache = c
+}
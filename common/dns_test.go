@@ -0,0 +1,136 @@
+package common
+
+import (
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/combaine/combaine/common/cache"
+	"github.com/combaine/combaine/common/hosts"
+	"github.com/combaine/combaine/repository"
+)
+
+func startTestDNSServer(t *testing.T, handler dns.HandlerFunc) (string, func()) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	started := make(chan struct{})
+	server := &dns.Server{PacketConn: pc, Handler: handler, NotifyStartedFunc: func() { close(started) }}
+	go server.ActivateAndServe()
+	<-started
+	return pc.LocalAddr().String(), func() { server.Shutdown() }
+}
+
+func TestDNSFetcher(t *testing.T) {
+	addr, shutdown := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		q := r.Question[0]
+		rr := func(s string) dns.RR {
+			record, err := dns.NewRR(s)
+			assert.NoError(t, err)
+			return record
+		}
+		switch q.Name {
+		case "front.example.net.":
+			switch q.Qtype {
+			case dns.TypeA:
+				m.Answer = append(m.Answer, rr("front.example.net. 60 IN A 10.0.0.1"),
+					rr("front.example.net. 60 IN A 10.0.0.2"))
+			case dns.TypeAAAA:
+				m.Answer = append(m.Answer, rr("front.example.net. 60 IN AAAA ::1"))
+			}
+		case "_http._tcp.front.example.net.":
+			m.Answer = append(m.Answer,
+				rr("_http._tcp.front.example.net. 60 IN SRV 0 0 80 sas1-front.example.net."),
+				rr("_http._tcp.front.example.net. 60 IN SRV 0 0 80 man2-front.example.net."),
+				rr("_http._tcp.front.example.net. 60 IN SRV 0 0 80 front.dc3.example.net."),
+				rr("_http._tcp.front.example.net. 60 IN SRV 0 0 80 other.net."))
+		case "hosts.example.net.":
+			m.Answer = append(m.Answer, rr(`hosts.example.net. 60 IN TXT "h1.example.net h2.example.net" "h3.example.net"`))
+		case "empty.example.net.":
+		case "broken.example.net.":
+			m.Rcode = dns.RcodeServerFailure
+		default:
+			m.Rcode = dns.RcodeNameError
+		}
+		w.WriteMsg(m)
+	})
+	defer shutdown()
+
+	// the first resolver does not answer
+	dead, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	deadAddr := dead.LocalAddr().String()
+	dead.Close()
+
+	cases := []struct {
+		config   repository.PluginConfig
+		name     string
+		expected hosts.Hosts
+		err      bool
+	}{
+		{repository.PluginConfig{}, "front.example.net", hosts.Hosts{"NoDC": {"10.0.0.1", "10.0.0.2"}}, false},
+		{repository.PluginConfig{"query_type": "aaaa", "dc_map": map[string]string{"::1": "local"}},
+			"front.example.net", hosts.Hosts{"local": {"::1"}}, false},
+		{repository.PluginConfig{"query_type": "SRV", "dc_regex": `^([a-z]+)\d-`,
+			"dc_map": map[string]string{"sas": "Sasovo", ".dc3.example.net": "dc3"}},
+			"_http._tcp.front.example.net", hosts.Hosts{
+				"Sasovo": {"sas1-front.example.net"}, "man": {"man2-front.example.net"},
+				"dc3": {"front.dc3.example.net"}, "NoDC": {"other.net"}}, false},
+		{repository.PluginConfig{"query_type": "TXT", "dc_regex": `^h\d`},
+			"hosts.example.net", hosts.Hosts{
+				"h1": {"h1.example.net"}, "h2": {"h2.example.net"}, "h3": {"h3.example.net"}}, false},
+		{repository.PluginConfig{"query_type": "SRV"}, "empty.example.net", nil, true},
+		{repository.PluginConfig{}, "missing.example.net", nil, true},
+		{repository.PluginConfig{}, "broken.example.net", nil, true},
+	}
+	for _, c := range cases {
+		c.config["type"] = "dns"
+		c.config["resolvers"] = []string{deadAddr, addr}
+		c.config["QueryTimeout"] = 1
+		f, err := LoadHostFetcher(c.config)
+		assert.NoError(t, err, c.config)
+		found, err := f.Fetch(c.name)
+		if c.err {
+			assert.Error(t, err, c.name)
+			continue
+		}
+		assert.NoError(t, err, c.name)
+		for _, v := range found {
+			sort.Strings(v)
+		}
+		assert.Equal(t, c.expected, found, c.name)
+	}
+
+	// the answer is cached before mapping to datacenters
+	shared := cache.NewCache(time.Minute, time.Minute, time.Minute)
+	for _, dc := range []string{"dc1", "dc2"} {
+		f, err := LoadHostFetcherWithCache(repository.PluginConfig{
+			"type": "dns", "resolvers": []string{addr},
+			"dc_map": map[string]string{"10.0.0.1": dc, "10.0.0.2": dc},
+		}, shared)
+		assert.NoError(t, err)
+		found, err := f.Fetch("front.example.net")
+		assert.NoError(t, err)
+		assert.Equal(t, hosts.Hosts{dc: {"10.0.0.1", "10.0.0.2"}}, found)
+	}
+
+	badConfigs := []repository.PluginConfig{
+		{"query_type": "MX"},
+		{"net": "sctp"},
+		{"dc_regex": "("},
+	}
+	for _, c := range badConfigs {
+		c["type"] = "dns"
+		_, err := LoadHostFetcher(c)
+		assert.Error(t, err, c)
+	}
+
+	f, err := newDNSFetcher(repository.PluginConfig{"resolvers": []string{"127.0.0.1"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"127.0.0.1:53"}, f.(*DNSFetcher).Resolvers)
+}
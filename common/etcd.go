@@ -0,0 +1,588 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/combaine/combaine/common/cache"
+	"github.com/combaine/combaine/common/chttp"
+	"github.com/combaine/combaine/common/hosts"
+	"github.com/combaine/combaine/repository"
+)
+
+// defaultEtcdKeyRegex match `host` or `dc/host` after the group prefix
+const defaultEtcdKeyRegex = `^(?:(?P<dc>[^/]+)/)?(?P<host>[^/]+)$`
+
+var (
+	// etcdRetryInterval is delay before watching keys again after error
+	etcdRetryInterval = 5 * time.Second
+	// etcdIdleTimeout is time after which unused watch is removed
+	etcdIdleTimeout = 30 * time.Minute
+)
+
+// EtcdFetcher resolve the group to keys under `prefix/group/` with etcd v3
+// json gateway, dc and host are parsed from the key or from json value
+type EtcdFetcher struct {
+	cache *cache.TTLCache
+	// Endpoints are queried in order until one of them answers
+	Endpoints []string `mapstructure:"endpoints"`
+	Prefix    string   `mapstructure:"prefix"`
+	// KeyRegex is matched against the key after the group prefix,
+	// it should have `host` and may have `dc` named submatches
+	KeyRegex string `mapstructure:"key_regex"`
+	// HostField and DCField are fields in json value
+	// overriding host and dc parsed from the key
+	HostField string `mapstructure:"host_field"`
+	DCField   string `mapstructure:"dc_field"`
+	// Labels are fields in json value copied to labels,
+	// all scalar fields are copied by default
+	Labels   []string `mapstructure:"labels"`
+	Username string   `mapstructure:"username"`
+	Password string   `mapstructure:"password"`
+	CAFile   string   `mapstructure:"ca_file"`
+	CertFile string   `mapstructure:"cert_file"`
+	KeyFile  string   `mapstructure:"key_file"`
+	// Watch keys for instant updates instead of listing them on cache miss
+	Watch       bool `mapstructure:"watch"`
+	ReadTimeout int64
+
+	keyRegex *regexp.Regexp
+}
+
+// newEtcdFetcher return etcd hosts fetcher
+func newEtcdFetcher(config repository.PluginConfig) (HostFetcher, error) {
+	var fetcher EtcdFetcher
+	if err := mapstructure.Decode(config, &fetcher); err != nil {
+		return nil, err
+	}
+	if len(fetcher.Endpoints) == 0 {
+		fetcher.Endpoints = []string{"http://127.0.0.1:2379"}
+	}
+	for idx, e := range fetcher.Endpoints {
+		fetcher.Endpoints[idx] = strings.TrimRight(e, "/")
+	}
+	if fetcher.KeyRegex == "" {
+		fetcher.KeyRegex = defaultEtcdKeyRegex
+	}
+	var err error
+	if fetcher.keyRegex, err = regexp.Compile(fetcher.KeyRegex); err != nil {
+		return nil, errors.Wrap(err, "etcd: key_regex")
+	}
+	if fetcher.HostField == "" {
+		fetcher.HostField = "host"
+	}
+	if fetcher.DCField == "" {
+		fetcher.DCField = "dc"
+	}
+	if fetcher.ReadTimeout <= 0 {
+		fetcher.ReadTimeout = 10
+	}
+
+	// check tls options, the client is taken for each request
+	// to pick up rotated certificates
+	if _, err := fetcher.httpClient(); err != nil {
+		return nil, err
+	}
+	return &fetcher, nil
+}
+
+// httpClient return client shared between fetchers with the same tls options
+func (e *EtcdFetcher) httpClient() (*http.Client, error) {
+	key := fmt.Sprintf("etcd|%s|%s|%s", e.CAFile, e.CertFile, e.KeyFile)
+	files := []string{e.CAFile, e.CertFile, e.KeyFile}
+	return chttp.CachedClient(key, files, e.newClient)
+}
+
+func (e *EtcdFetcher) newClient() (*http.Client, error) {
+	tlsConfig := &tls.Config{}
+	if e.CAFile != "" {
+		if err := loadCAFile(tlsConfig, e.CAFile); err != nil {
+			return nil, errors.Wrap(err, "etcd")
+		}
+	}
+	if e.CertFile != "" || e.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(e.CertFile, e.KeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "etcd: client certificate")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return &http.Client{Transport: &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		TLSClientConfig:     tlsConfig,
+		TLSHandshakeTimeout: 10 * time.Second,
+		MaxIdleConns:        10,
+		IdleConnTimeout:     90 * time.Second,
+	}}, nil
+}
+
+type etcdKV struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+type etcdHeader struct {
+	Revision int64 `json:"revision,string"`
+}
+
+type etcdRangeResponse struct {
+	Header etcdHeader `json:"header"`
+	Kvs    []etcdKV   `json:"kvs"`
+}
+
+type etcdWatchResponse struct {
+	Result struct {
+		Header   etcdHeader `json:"header"`
+		Canceled bool       `json:"canceled"`
+		Events   []struct {
+			Type string `json:"type"`
+			KV   etcdKV `json:"kv"`
+		} `json:"events"`
+	} `json:"result"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Fetch resolve the group in the list of hosts
+func (e *EtcdFetcher) Fetch(group string) (hosts.Hosts, error) {
+	response, _, err := e.FetchLabeled(group)
+	return response, err
+}
+
+// FetchLabeled resolve the group in the list of hosts,
+// hosts are labeled with fields of json values
+func (e *EtcdFetcher) FetchLabeled(group string) (hosts.Hosts, hosts.Labels, error) {
+	prefix := path.Join("/", e.Prefix, group) + "/"
+	fetcher := func() (map[string][]string, map[string]map[string]string, error) {
+		kvs, err := e.list(prefix)
+		if err != nil {
+			return nil, nil, err
+		}
+		return e.hosts(prefix, kvs)
+	}
+	if e.cache != nil {
+		key := fmt.Sprintf("etcd:%s:%s|key_regex=%s|host_field=%s|dc_field=%s|labels=%s|user=%s",
+			strings.Join(e.Endpoints, ","), prefix, e.KeyRegex, e.HostField, e.DCField,
+			strings.Join(e.Labels, ","), e.Username)
+		return e.cache.GetLabeledHosts(group, key, fetcher)
+	}
+	return fetcher()
+}
+
+// list return keys with the prefix and their values,
+// watched keys are returned without listing
+func (e *EtcdFetcher) list(prefix string) (map[string][]byte, error) {
+	if e.Watch {
+		w := e.watch(prefix)
+		select {
+		case <-w.ready:
+		case <-time.After(time.Duration(e.ReadTimeout) * time.Second):
+			return nil, errors.Errorf("etcd: timeout while listing %s", prefix)
+		}
+		return w.get()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(e.ReadTimeout)*time.Second)
+	defer cancel()
+	resp, err := e.rangePrefix(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	kvs := make(map[string][]byte, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		kvs[string(kv.Key)] = kv.Value
+	}
+	return kvs, nil
+}
+
+// hosts parse hosts from keys and values
+func (e *EtcdFetcher) hosts(prefix string, kvs map[string][]byte) (hosts.Hosts, hosts.Labels, error) {
+	log := logrus.WithField("source", "EtcdFetcher")
+
+	response := make(hosts.Hosts)
+	labels := make(hosts.Labels)
+	for key, value := range kvs {
+		var host, dc string
+		if m := e.keyRegex.FindStringSubmatch(strings.TrimPrefix(key, prefix)); m != nil {
+			for idx, name := range e.keyRegex.SubexpNames() {
+				switch name {
+				case "host":
+					host = m[idx]
+				case "dc":
+					dc = m[idx]
+				}
+			}
+		}
+		var hostLabels map[string]string
+		if len(value) > 0 {
+			if h, d, l, err := parseHostData(value, e.HostField, e.DCField, e.Labels); err == nil {
+				if h != "" {
+					host = h
+				}
+				if d != "" {
+					dc = d
+				}
+				hostLabels = l
+			}
+		}
+		if host == "" {
+			log.Errorf("Unable to parse host from key %s", key)
+			continue
+		}
+		if dc == "" {
+			dc = "NoDC"
+		}
+		response[dc] = append(response[dc], host)
+		if len(hostLabels) > 0 {
+			labels[host] = hostLabels
+		}
+	}
+	if len(response) == 0 {
+		return response, labels, ErrNoHosts
+	}
+	return response, labels, nil
+}
+
+// etcdPrefixEnd return end of the range with all keys having the prefix
+func etcdPrefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return []byte{0}
+}
+
+func (e *EtcdFetcher) rangePrefix(ctx context.Context, prefix string) (*etcdRangeResponse, error) {
+	var result etcdRangeResponse
+	body := map[string]interface{}{"key": []byte(prefix), "range_end": etcdPrefixEnd(prefix)}
+	resp, err := e.post(ctx, "/v3/kv/range", body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("Failed to parse json body: %s", err)
+	}
+	return &result, nil
+}
+
+// post the request to endpoints in order until one of them answers
+func (e *EtcdFetcher) post(ctx context.Context, api string, body interface{}) (*http.Response, error) {
+	log := logrus.WithField("source", "EtcdFetcher")
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	var lastErr error
+	for _, endpoint := range e.Endpoints {
+		resp, err := e.postEndpoint(ctx, endpoint, api, data)
+		if err != nil {
+			log.Error(err)
+			lastErr = err
+			continue
+		}
+		return resp, nil
+	}
+	return nil, lastErr
+}
+
+// postEndpoint post the request to the endpoint with cached token,
+// the token is obtained again when the endpoint rejects it
+func (e *EtcdFetcher) postEndpoint(ctx context.Context, endpoint, api string, data []byte) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		token := ""
+		if e.Username != "" {
+			var err error
+			if token, err = e.token(ctx, endpoint); err != nil {
+				return nil, errors.Wrapf(err, "Unable to authenticate on %s", endpoint)
+			}
+		}
+		req, err := http.NewRequest("POST", endpoint+api, bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		client, err := e.httpClient()
+		if err != nil {
+			return nil, err
+		}
+		resp, err := chttp.DoWithClient(ctx, client, req)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Unable to query %s", endpoint+api)
+		}
+		if resp.StatusCode == http.StatusUnauthorized && token != "" && attempt == 0 {
+			resp.Body.Close()
+			e.dropToken(endpoint, token)
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, errors.Errorf("%s answered with %s", endpoint+api, resp.Status)
+		}
+		return resp, nil
+	}
+}
+
+// etcdTokens are auth tokens shared between fetchers with the same endpoint and user
+var etcdTokens = struct {
+	sync.Mutex
+	m map[string]string
+}{m: make(map[string]string)}
+
+func (e *EtcdFetcher) tokenKey(endpoint string) string {
+	return fmt.Sprintf("%s|%s|%s", endpoint, e.Username, e.Password)
+}
+
+// token return cached token for the endpoint or authenticate to get new one
+func (e *EtcdFetcher) token(ctx context.Context, endpoint string) (string, error) {
+	key := e.tokenKey(endpoint)
+	etcdTokens.Lock()
+	token, ok := etcdTokens.m[key]
+	etcdTokens.Unlock()
+	if ok {
+		return token, nil
+	}
+	token, err := e.authenticate(ctx, endpoint)
+	if err != nil {
+		return "", err
+	}
+	etcdTokens.Lock()
+	etcdTokens.m[key] = token
+	etcdTokens.Unlock()
+	return token, nil
+}
+
+// dropToken remove rejected token, so it is obtained again on the next request
+func (e *EtcdFetcher) dropToken(endpoint, token string) {
+	key := e.tokenKey(endpoint)
+	etcdTokens.Lock()
+	if etcdTokens.m[key] == token {
+		delete(etcdTokens.m, key)
+	}
+	etcdTokens.Unlock()
+}
+
+func (e *EtcdFetcher) authenticate(ctx context.Context, endpoint string) (string, error) {
+	data, err := json.Marshal(map[string]string{"name": e.Username, "password": e.Password})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest("POST", endpoint+"/v3/auth/authenticate", bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client, err := e.httpClient()
+	if err != nil {
+		return "", err
+	}
+	resp, err := chttp.DoWithClient(ctx, client, req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("authenticate answered with %s: %s", resp.Status, body)
+	}
+	var result struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", err
+	}
+	return result.Token, nil
+}
+
+func (e *EtcdFetcher) setCache(c *cache.TTLCache) {
+	e.cache = c
+}
+
+// etcdWatch is the current keys with the prefix and their values
+type etcdWatch struct {
+	sync.Mutex
+	kvs      map[string][]byte
+	err      error
+	accessed time.Time
+	ready    chan struct{}
+	once     sync.Once
+	// fetcher is the last fetcher used the watch,
+	// its options are used on each reconnect
+	fetcher *EtcdFetcher
+}
+
+// etcdWatches are shared between fetchers with the same endpoints,
+// tls and auth options and prefix
+var etcdWatches = struct {
+	sync.Mutex
+	m map[string]*etcdWatch
+}{m: make(map[string]*etcdWatch)}
+
+// watch return watch of the prefix, watching is started if needed
+func (e *EtcdFetcher) watch(prefix string) *etcdWatch {
+	key := fmt.Sprintf("%s|%s|%s|%s|%s|%s|%s", strings.Join(e.Endpoints, ","),
+		e.Username, e.Password, e.CAFile, e.CertFile, e.KeyFile, prefix)
+	etcdWatches.Lock()
+	defer etcdWatches.Unlock()
+	w, ok := etcdWatches.m[key]
+	if !ok {
+		w = &etcdWatch{ready: make(chan struct{})}
+		etcdWatches.m[key] = w
+		ctx, cancel := context.WithCancel(context.Background())
+		go w.dropIdle(key, cancel)
+		go w.run(ctx, prefix)
+	}
+	w.Lock()
+	w.accessed = time.Now()
+	w.fetcher = e
+	w.Unlock()
+	return w
+}
+
+// run list keys and watch changes until ctx is canceled,
+// client, token and timeouts are taken from the current fetcher on each reconnect
+func (w *etcdWatch) run(ctx context.Context, prefix string) {
+	log := logrus.WithField("source", "EtcdFetcher")
+	for {
+		w.Lock()
+		e := w.fetcher
+		w.Unlock()
+		err := e.listAndWatch(ctx, prefix, w)
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		log.Errorf("Watch of %s failed: %s", prefix, err)
+		w.set(nil, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(etcdRetryInterval):
+		}
+	}
+}
+
+func (e *EtcdFetcher) listAndWatch(ctx context.Context, prefix string, w *etcdWatch) error {
+	listCtx, cancel := context.WithTimeout(ctx, time.Duration(e.ReadTimeout)*time.Second)
+	list, err := e.rangePrefix(listCtx, prefix)
+	cancel()
+	if err != nil {
+		return err
+	}
+	kvs := make(map[string][]byte, len(list.Kvs))
+	for _, kv := range list.Kvs {
+		kvs[string(kv.Key)] = kv.Value
+	}
+	w.set(kvs, nil)
+
+	resp, err := e.post(ctx, "/v3/watch", map[string]interface{}{
+		"create_request": map[string]interface{}{
+			"key":            []byte(prefix),
+			"range_end":      etcdPrefixEnd(prefix),
+			"start_revision": list.Header.Revision + 1,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event etcdWatchResponse
+		if err := decoder.Decode(&event); err != nil {
+			return err
+		}
+		if event.Error != nil {
+			return errors.New(event.Error.Message)
+		}
+		if event.Result.Canceled {
+			return errors.New("watch canceled")
+		}
+		if len(event.Result.Events) == 0 {
+			continue
+		}
+		updated := w.copyKVs()
+		for _, ev := range event.Result.Events {
+			if ev.Type == "DELETE" {
+				delete(updated, string(ev.KV.Key))
+			} else {
+				updated[string(ev.KV.Key)] = ev.KV.Value
+			}
+		}
+		w.set(updated, nil)
+	}
+}
+
+// dropIdle remove the watch and stop watching when it is not used
+func (w *etcdWatch) dropIdle(key string, cancel context.CancelFunc) {
+	ticker := time.NewTicker(etcdIdleTimeout / 2)
+	defer ticker.Stop()
+	for range ticker.C {
+		etcdWatches.Lock()
+		w.Lock()
+		idle := time.Since(w.accessed) > etcdIdleTimeout
+		w.Unlock()
+		if idle {
+			delete(etcdWatches.m, key)
+		}
+		etcdWatches.Unlock()
+		if idle {
+			cancel()
+			return
+		}
+	}
+}
+
+// set current keys, on error the previous keys are kept
+func (w *etcdWatch) set(kvs map[string][]byte, err error) {
+	w.Lock()
+	if err == nil {
+		w.kvs, w.err = kvs, nil
+	} else if w.kvs == nil {
+		w.err = err
+	}
+	w.Unlock()
+	w.once.Do(func() { close(w.ready) })
+}
+
+func (w *etcdWatch) get() (map[string][]byte, error) {
+	w.Lock()
+	defer w.Unlock()
+	return w.kvs, w.err
+}
+
+func (w *etcdWatch) copyKVs() map[string][]byte {
+	w.Lock()
+	defer w.Unlock()
+	kvs := make(map[string][]byte, len(w.kvs))
+	for k, v := range w.kvs {
+		kvs[k] = v
+	}
+	return kvs
+}
@@ -0,0 +1,284 @@
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/combaine/combaine/common/cache"
+	"github.com/combaine/combaine/common/hosts"
+	"github.com/combaine/combaine/repository"
+)
+
+type fakeEtcd struct {
+	sync.Mutex
+	kvs     map[string]string
+	events  chan string
+	ranges  int
+	watches int
+	auths   int
+	token   string
+}
+
+func (f *fakeEtcd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&req)
+	f.Lock()
+	token := f.token
+	f.Unlock()
+	if token == "" {
+		token = "tkn"
+	}
+	if r.URL.Path == "/v3/auth/authenticate" {
+		if req["name"] == "root" && req["password"] == "secret" {
+			f.Lock()
+			f.auths++
+			f.Unlock()
+			fmt.Fprintf(w, `{"token": %q}`, token)
+		} else {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+		return
+	}
+	if r.Header.Get("Authorization") != token {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/v3/kv/range":
+		var key, end []byte
+		json.Unmarshal([]byte(fmt.Sprintf("%q", req["key"])), &key)
+		json.Unmarshal([]byte(fmt.Sprintf("%q", req["range_end"])), &end)
+		f.Lock()
+		f.ranges++
+		var kvs []etcdKV
+		for k, v := range f.kvs {
+			if k >= string(key) && k < string(end) {
+				kvs = append(kvs, etcdKV{Key: []byte(k), Value: []byte(v)})
+			}
+		}
+		f.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"header": map[string]string{"revision": "7"},
+			"kvs":    kvs,
+		})
+	case "/v3/watch":
+		create := req["create_request"].(map[string]interface{})
+		if create["start_revision"] != float64(8) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.Lock()
+		f.watches++
+		f.Unlock()
+		fmt.Fprint(w, `{"result": {"header": {"revision": "7"}, "created": true}}`)
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case ev := <-f.events:
+				fmt.Fprint(w, ev)
+				w.(http.Flusher).Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestEtcdFetcher(t *testing.T) {
+	fake := &fakeEtcd{
+		kvs: map[string]string{
+			"/services/front/dc1/front1": "",
+			"/services/front/dc2/front2": `{"shard": "2", "version": 3}`,
+			"/services/front/front3":     `{"dc": "dc3", "host": "front3.example.com"}`,
+			"/services/frontend/dc1/f1":  "",
+			"/services/back/b1":          `{"node": "back1", "zone": "dc1"}`,
+		},
+		events: make(chan string, 1),
+	}
+	ts := httptest.NewUnstartedServer(fake)
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	ts.StartTLS()
+	defer func() {
+		// watch stream is never idle
+		ts.CloseClientConnections()
+		ts.Close()
+	}()
+
+	dir, err := ioutil.TempDir("", "combaine-etcd")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cert := ts.TLS.Certificates[0]
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	assert.NoError(t, err)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "ca.crt"), certPEM, 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "client.crt"), certPEM, 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "client.key"),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0600))
+
+	config := func(extra repository.PluginConfig) repository.PluginConfig {
+		cfg := repository.PluginConfig{
+			"type":      "etcd",
+			"endpoints": []string{"https://127.0.0.1:1", ts.URL + "/"},
+			"prefix":    "services",
+			"username":  "root",
+			"password":  "secret",
+			"ca_file":   filepath.Join(dir, "ca.crt"),
+			"cert_file": filepath.Join(dir, "client.crt"),
+			"key_file":  filepath.Join(dir, "client.key"),
+		}
+		for k, v := range extra {
+			cfg[k] = v
+		}
+		return cfg
+	}
+
+	f, err := LoadHostFetcher(config(nil))
+	assert.NoError(t, err)
+	found, labels, err := FetchLabeled(f, "front")
+	assert.NoError(t, err)
+	assert.Equal(t, hosts.Hosts{"dc1": {"front1"}, "dc2": {"front2"}, "dc3": {"front3.example.com"}}, found)
+	assert.Equal(t, hosts.Labels{"front2": {"shard": "2", "version": "3"}}, labels)
+
+	f, err = LoadHostFetcher(config(repository.PluginConfig{
+		"host_field": "node", "dc_field": "zone", "key_regex": `^b(?P<host>\d+)$`,
+	}))
+	assert.NoError(t, err)
+	found, err = f.Fetch("back")
+	assert.NoError(t, err)
+	assert.Equal(t, hosts.Hosts{"dc1": {"back1"}}, found)
+
+	_, err = f.Fetch("unknown")
+	assert.Equal(t, ErrNoHosts, err)
+
+	badConfigs := []repository.PluginConfig{
+		config(repository.PluginConfig{"password": "wrong"}),
+		config(repository.PluginConfig{"cert_file": ""}),
+	}
+	for _, c := range badConfigs {
+		f, err := LoadHostFetcher(c)
+		if err == nil {
+			_, err = f.Fetch("front")
+		}
+		assert.Error(t, err, c)
+	}
+	_, err = LoadHostFetcher(config(repository.PluginConfig{"key_regex": "("}))
+	assert.Error(t, err)
+
+	// watched keys are updated without listing
+	f, err = LoadHostFetcher(config(repository.PluginConfig{"watch": true}))
+	assert.NoError(t, err)
+	found, err = f.Fetch("front")
+	assert.NoError(t, err)
+	assert.Len(t, found, 3)
+	assert.True(t, waitFor(func() bool {
+		fake.Lock()
+		defer fake.Unlock()
+		return fake.watches == 1
+	}), "watch started")
+	fake.Lock()
+	ranges := fake.ranges
+	fake.Unlock()
+
+	fake.events <- `{"result": {"header": {"revision": "8"}, "events": [
+{"type": "DELETE", "kv": {"key": "L3NlcnZpY2VzL2Zyb250L2RjMS9mcm9udDE="}},
+{"kv": {"key": "L3NlcnZpY2VzL2Zyb250L2RjMS9mcm9udDQ=", "value": ""}}]}}`
+	assert.True(t, waitFor(func() bool {
+		found, err = f.Fetch("front")
+		return err == nil && len(found["dc1"]) == 1 && found["dc1"][0] == "front4"
+	}), "watch update")
+	var all []string
+	for _, h := range found {
+		all = append(all, h...)
+	}
+	sort.Strings(all)
+	assert.Equal(t, "front2,front3.example.com,front4", strings.Join(all, ","))
+	fake.Lock()
+	assert.Equal(t, ranges, fake.ranges)
+	fake.Unlock()
+
+	// watched hosts are served through the cache,
+	// hosts parsed with different options are cached separately
+	ttlCache := cache.NewCache(time.Minute, time.Minute, time.Minute)
+	cf, err := LoadHostFetcherWithCache(config(repository.PluginConfig{"watch": true}), ttlCache)
+	assert.NoError(t, err)
+	found, err = cf.Fetch("front")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"front4"}, found["dc1"])
+	cf, err = LoadHostFetcherWithCache(config(repository.PluginConfig{"watch": true, "dc_field": "shard"}), ttlCache)
+	assert.NoError(t, err)
+	found, err = cf.Fetch("front")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"front2"}, found["2"])
+	assert.Len(t, ttlCache.Entries(), 2)
+	fake.Lock()
+	assert.Equal(t, 1, fake.watches)
+	fake.Unlock()
+
+	// fetchers with other tls options do not share the watch
+	certPath := filepath.Join(dir, "client2.crt")
+	assert.NoError(t, ioutil.WriteFile(certPath, certPEM, 0644))
+	cf, err = LoadHostFetcher(config(repository.PluginConfig{"watch": true, "cert_file": certPath}))
+	assert.NoError(t, err)
+	_, err = cf.Fetch("front")
+	assert.NoError(t, err)
+	assert.True(t, waitFor(func() bool {
+		fake.Lock()
+		defer fake.Unlock()
+		return fake.watches == 2
+	}), "second watch started")
+}
+
+func TestEtcdToken(t *testing.T) {
+	fake := &fakeEtcd{kvs: map[string]string{"/services/front/dc1/front1": ""}}
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+
+	f, err := LoadHostFetcher(repository.PluginConfig{
+		"type":      "etcd",
+		"endpoints": []string{ts.URL},
+		"prefix":    "services",
+		"username":  "root",
+		"password":  "secret",
+	})
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = f.Fetch("front")
+		assert.NoError(t, err)
+	}
+	fake.Lock()
+	assert.Equal(t, 1, fake.auths, "token is cached")
+	fake.token = "rotated"
+	fake.Unlock()
+
+	found, err := f.Fetch("front")
+	assert.NoError(t, err)
+	assert.Equal(t, hosts.Hosts{"dc1": {"front1"}}, found)
+	fake.Lock()
+	assert.Equal(t, 2, fake.auths, "authenticated again after 401")
+	fake.Unlock()
+}
+
+func TestEtcdPrefixEnd(t *testing.T) {
+	assert.Equal(t, []byte("/a/c"), etcdPrefixEnd("/a/b"))
+	assert.Equal(t, []byte("/b"), etcdPrefixEnd("/a\xff"))
+	assert.Equal(t, []byte{0}, etcdPrefixEnd("\xff"))
+}
@@ -0,0 +1,186 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/combaine/combaine/common/cache"
+	"github.com/combaine/combaine/common/hosts"
+	"github.com/combaine/combaine/repository"
+)
+
+const defaultHostsPath = "hosts"
+
+// FileFetcher read groups from yaml or json files in form
+// `group: {datacenter: [host, ...]}`, the files are reloaded when they change
+type FileFetcher struct {
+	// Path to the file or to the directory with *.yaml, *.yml and *.json files,
+	// relative path is relative to the config repository
+	Path string `mapstructure:"path"`
+}
+
+// hostsInventory is parsed content of the hosts files
+type hostsInventory struct {
+	signature string
+	groups    map[string]hosts.Hosts
+	err       error
+	// failedSignature is signature of files failed to load,
+	// they are not parsed again until changed
+	failedSignature string
+}
+
+// inventories are shared between fetchers, they are recreated for each iteration
+var inventories = struct {
+	sync.Mutex
+	m map[string]*hostsInventory
+}{m: make(map[string]*hostsInventory)}
+
+// newFileFetcher return hosts fetcher reading groups from files
+func newFileFetcher(config repository.PluginConfig) (HostFetcher, error) {
+	var fetcher FileFetcher
+	if err := mapstructure.Decode(config, &fetcher); err != nil {
+		return nil, err
+	}
+	if fetcher.Path == "" {
+		fetcher.Path = defaultHostsPath
+	}
+	if !filepath.IsAbs(fetcher.Path) {
+		fetcher.Path = filepath.Join(repository.GetBasePath(), fetcher.Path)
+	}
+	return &fetcher, nil
+}
+
+// Fetch return hosts of the group from files
+func (f *FileFetcher) Fetch(group string) (hosts.Hosts, error) {
+	inv, err := loadInventory(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	found, ok := inv.groups[group]
+	if !ok {
+		return nil, fmt.Errorf("hosts for group `%s` are not specified", group)
+	}
+	response := make(hosts.Hosts, len(found))
+	response.Merge(&found)
+	if len(response) == 0 {
+		return response, ErrNoHosts
+	}
+	return response, nil
+}
+
+func (f *FileFetcher) setCache(_ *cache.TTLCache) {}
+
+func isHostsFile(name string) bool {
+	switch filepath.Ext(name) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+// hostsFiles return files under path and their signature
+func hostsFiles(path string) ([]string, string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, "", err
+	}
+	var infos []os.FileInfo
+	var files []string
+	if info.IsDir() {
+		listing, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, i := range listing {
+			if !i.IsDir() && isHostsFile(i.Name()) {
+				infos = append(infos, i)
+				files = append(files, filepath.Join(path, i.Name()))
+			}
+		}
+	} else {
+		infos = []os.FileInfo{info}
+		files = []string{path}
+	}
+	// ReadDir returns sorted listing
+	var sig strings.Builder
+	for _, i := range infos {
+		fmt.Fprintf(&sig, "%s:%d:%d;", i.Name(), i.Size(), i.ModTime().UnixNano())
+	}
+	return files, sig.String(), nil
+}
+
+// loadInventory return parsed hosts files, they are parsed again only if changed
+func loadInventory(path string) (*hostsInventory, error) {
+	log := logrus.WithField("source", "FileFetcher")
+
+	files, signature, err := hostsFiles(path)
+	if err != nil {
+		return nil, err
+	}
+
+	inventories.Lock()
+	defer inventories.Unlock()
+	if inv, ok := inventories.m[path]; ok && (inv.signature == signature || inv.failedSignature == signature) {
+		return inv, inv.err
+	}
+
+	log.Infof("Load hosts from %s", path)
+	inv := &hostsInventory{signature: signature, groups: make(map[string]hosts.Hosts)}
+	definedIn := make(map[string]string)
+	for _, file := range files {
+		groups, err := readHostsFile(file)
+		if err != nil {
+			inv.err = errors.Wrapf(err, "failed to read %s", file)
+			break
+		}
+		for name, h := range groups {
+			if other, ok := definedIn[name]; ok {
+				inv.err = errors.Errorf("group `%s` is defined in %s and %s", name, other, file)
+				break
+			}
+			definedIn[name] = file
+			inv.groups[name] = h
+		}
+		if inv.err != nil {
+			break
+		}
+	}
+	if inv.err != nil {
+		log.Errorf("Failed to load hosts from %s: %s", path, inv.err)
+		if prev, ok := inventories.m[path]; ok && prev.err == nil {
+			// keep serving the last good inventory
+			log.Warnf("Use previously loaded hosts from %s", path)
+			prev.failedSignature = signature
+			return prev, nil
+		}
+	}
+	inventories.m[path] = inv
+	return inv, inv.err
+}
+
+func readHostsFile(file string) (map[string]hosts.Hosts, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var groups map[string]hosts.Hosts
+	if filepath.Ext(file) == ".json" {
+		err = json.Unmarshal(data, &groups)
+	} else {
+		err = yaml.Unmarshal(data, &groups)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
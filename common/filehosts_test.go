@@ -0,0 +1,87 @@
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/combaine/combaine/common/hosts"
+	"github.com/combaine/combaine/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileFetcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "combaine-hosts")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	write := func(name, content string) {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+		// make the change visible on file systems with coarse mtime
+		mtime := time.Now().Add(time.Duration(len(content)) * time.Second)
+		assert.NoError(t, os.Chtimes(path, mtime, mtime))
+	}
+	write("front.yaml", "front:\n  dc1: [front1, front2]\n  dc2: [front3]\n")
+	write("back.json", `{"back": {"dc1": ["back1"]}, "empty": {}}`)
+	write("README", "not hosts")
+
+	f, err := LoadHostFetcher(repository.PluginConfig{"type": "file", "path": dir})
+	assert.NoError(t, err)
+
+	found, err := f.Fetch("front")
+	assert.NoError(t, err)
+	assert.Equal(t, hosts.Hosts{"dc1": {"front1", "front2"}, "dc2": {"front3"}}, found)
+	found, err = f.Fetch("back")
+	assert.NoError(t, err)
+	assert.Equal(t, hosts.Hosts{"dc1": {"back1"}}, found)
+	_, err = f.Fetch("empty")
+	assert.Equal(t, ErrNoHosts, err)
+	_, err = f.Fetch("unknown")
+	assert.Error(t, err)
+
+	// result is a copy of the inventory
+	found["dc1"][0] = "changed"
+	found, _ = f.Fetch("back")
+	assert.Equal(t, "back1", found["dc1"][0])
+
+	// changes are picked up without restart
+	write("front.yaml", "front:\n  dc1: [front1]\n")
+	found, err = f.Fetch("front")
+	assert.NoError(t, err)
+	assert.Equal(t, hosts.Hosts{"dc1": {"front1"}}, found)
+
+	// broken or conflicting files do not drop loaded hosts
+	write("broken.yml", "front: [")
+	found, err = f.Fetch("front")
+	assert.NoError(t, err)
+	assert.Equal(t, hosts.Hosts{"dc1": {"front1"}}, found)
+	// broken files are not parsed again until changed
+	_, signature, err := hostsFiles(dir)
+	assert.NoError(t, err)
+	inventories.Lock()
+	assert.Equal(t, signature, inventories.m[dir].failedSignature)
+	inventories.Unlock()
+	found, err = f.Fetch("front")
+	assert.NoError(t, err)
+	assert.Equal(t, hosts.Hosts{"dc1": {"front1"}}, found)
+	assert.NoError(t, os.Remove(filepath.Join(dir, "broken.yml")))
+	write("dup.yml", "back: {dc3: [back3]}\n")
+	found, err = f.Fetch("back")
+	assert.NoError(t, err)
+	assert.Equal(t, hosts.Hosts{"dc1": {"back1"}}, found)
+
+	// single file
+	f, err = LoadHostFetcher(repository.PluginConfig{"type": "file", "path": filepath.Join(dir, "dup.yml")})
+	assert.NoError(t, err)
+	found, err = f.Fetch("back")
+	assert.NoError(t, err)
+	assert.Equal(t, hosts.Hosts{"dc3": {"back3"}}, found)
+
+	f, err = LoadHostFetcher(repository.PluginConfig{"type": "file", "path": filepath.Join(dir, "missing")})
+	assert.NoError(t, err)
+	_, err = f.Fetch("back")
+	assert.Error(t, err)
+}
@@ -0,0 +1,146 @@
+package hosts
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Eval evaluate expression over groups of hosts, where `a + b` is union,
+// `a - b` is difference and `a & b` is intersection which binds tighter,
+// parentheses group subexpressions. Operators are separated by spaces,
+// so `-` may be a part of the group name. Groups are resolved by resolve
+func Eval(expr string, resolve func(group string) (Hosts, error)) (Hosts, error) {
+	p := &exprParser{tokens: tokenize(expr), resolve: resolve}
+	if len(p.tokens) == 0 {
+		return nil, fmt.Errorf("empty hosts expression")
+	}
+	result, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in hosts expression %q", p.tokens[p.pos], expr)
+	}
+	return result, nil
+}
+
+// Groups return names of groups used in the expression
+func Groups(expr string) []string {
+	var groups []string
+	for _, t := range tokenize(expr) {
+		switch t {
+		case "+", "-", "&", "(", ")":
+		default:
+			groups = append(groups, t)
+		}
+	}
+	return groups
+}
+
+func tokenize(expr string) []string {
+	var tokens []string
+	var current strings.Builder
+	flush := func() {
+		if current.Len() > 0 {
+			tokens = append(tokens, current.String())
+			current.Reset()
+		}
+	}
+	for _, r := range expr {
+		switch {
+		case unicode.IsSpace(r):
+			flush()
+		case r == '(' || r == ')':
+			flush()
+			tokens = append(tokens, string(r))
+		default:
+			current.WriteRune(r)
+		}
+	}
+	flush()
+	return tokens
+}
+
+type exprParser struct {
+	tokens  []string
+	pos     int
+	resolve func(group string) (Hosts, error)
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+// parseExpr parse `term (('+'|'-') term)*`
+func (p *exprParser) parseExpr() (Hosts, error) {
+	result, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == "+" || op == "-"; op = p.peek() {
+		p.pos++
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		if op == "+" {
+			merged := make(Hosts)
+			merged.Merge(&result)
+			merged.Merge(&right)
+			result = merged
+		} else {
+			result = result.Subtract(&right)
+		}
+	}
+	return result, nil
+}
+
+// parseTerm parse `factor ('&' factor)*`
+func (p *exprParser) parseTerm() (Hosts, error) {
+	result, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "&" {
+		p.pos++
+		right, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		result = result.Intersect(&right)
+	}
+	return result, nil
+}
+
+// parseFactor parse `group | '(' expr ')'`
+func (p *exprParser) parseFactor() (Hosts, error) {
+	t := p.peek()
+	p.pos++
+	switch t {
+	case "":
+		return nil, fmt.Errorf("unexpected end of hosts expression")
+	case "(":
+		result, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing ')' in hosts expression")
+		}
+		p.pos++
+		return result, nil
+	case ")", "+", "-", "&":
+		return nil, fmt.Errorf("unexpected %q in hosts expression", t)
+	}
+	found, err := p.resolve(t)
+	if err != nil {
+		return nil, fmt.Errorf("group %s: %s", t, err)
+	}
+	result := make(Hosts)
+	result.Merge(&found)
+	return result, nil
+}
@@ -1,6 +1,10 @@
 package hosts
 
-import "github.com/combaine/combaine/utils"
+import (
+	"sort"
+
+	"github.com/combaine/combaine/utils"
+)
 
 // Hosts represent map of the DC to list of the hosts
 type Hosts map[string][]string
@@ -32,9 +36,61 @@ func (h *Hosts) RemoteHosts() []string {
 	return h.getHosts(true)
 }
 
-// Merge all hosts in all datacenters
+// Datacenters return sorted names of datacenters
+func (h *Hosts) Datacenters() []string {
+	dcs := make([]string, 0, len(*h))
+	for dc := range *h {
+		dcs = append(dcs, dc)
+	}
+	sort.Strings(dcs)
+	return dcs
+}
+
+// Merge all hosts in all datacenters, hosts already present are skipped
 func (h *Hosts) Merge(other *Hosts) {
+	present := h.set()
 	for dc, v := range *other {
-		(*h)[dc] = append((*h)[dc], v...)
+		for _, host := range v {
+			if !present[host] {
+				present[host] = true
+				(*h)[dc] = append((*h)[dc], host)
+			}
+		}
+	}
+}
+
+// Intersect return hosts present in both h and other
+func (h *Hosts) Intersect(other *Hosts) Hosts {
+	present := other.set()
+	return h.Filter(func(host string) bool { return present[host] })
+}
+
+// Subtract return hosts from h not present in other
+func (h *Hosts) Subtract(other *Hosts) Hosts {
+	present := other.set()
+	return h.Filter(func(host string) bool { return !present[host] })
+}
+
+// Filter return hosts for which keep returns true,
+// datacenters without hosts are omitted
+func (h *Hosts) Filter(keep func(host string) bool) Hosts {
+	result := make(Hosts)
+	for dc, v := range *h {
+		for _, host := range v {
+			if keep(host) {
+				result[dc] = append(result[dc], host)
+			}
+		}
+	}
+	return result
+}
+
+func (h *Hosts) set() map[string]bool {
+	present := make(map[string]bool)
+	for _, v := range *h {
+		for _, host := range v {
+			present[host] = true
+		}
 	}
+	return present
 }
@@ -1,6 +1,7 @@
 package hosts
 
 import (
+	"fmt"
 	"sort"
 	"testing"
 
@@ -23,3 +24,90 @@ func TestCommonHostsUtil(t *testing.T) {
 	sort.Strings(lHosts)
 	assert.EqualValues(t, hosts["DC1"], lHosts)
 }
+
+func TestHostsSetOperations(t *testing.T) {
+	a := Hosts{"DC1": {"h1", "h2"}, "DC2": {"h3"}}
+	b := Hosts{"DC1": {"h2", "h4"}, "DC2": {"h3", "h3"}}
+
+	merged := make(Hosts)
+	merged.Merge(&a)
+	merged.Merge(&b)
+	assert.Equal(t, Hosts{"DC1": {"h1", "h2", "h4"}, "DC2": {"h3"}}, merged)
+
+	assert.Equal(t, Hosts{"DC1": {"h2"}, "DC2": {"h3"}}, a.Intersect(&b))
+	assert.Equal(t, Hosts{"DC1": {"h1"}}, a.Subtract(&b))
+	assert.Equal(t, Hosts{}, a.Filter(func(string) bool { return false }))
+
+	dcs := Hosts{"DC3": nil, "DC1": nil, "DC2": nil}
+	assert.Equal(t, []string{"DC1", "DC2", "DC3"}, dcs.Datacenters())
+}
+
+func TestEval(t *testing.T) {
+	groups := map[string]Hosts{
+		"front":       {"DC1": {"f1", "f2"}, "DC2": {"f3"}},
+		"back":        {"DC1": {"b1"}},
+		"maintenance": {"DC1": {"f2", "b1"}},
+		"dc1-only":    {"DC1": {"f1", "f2", "b1"}},
+		"zk:/path":    {"DC2": {"f3"}},
+	}
+	resolve := func(group string) (Hosts, error) {
+		h, ok := groups[group]
+		if !ok {
+			return nil, fmt.Errorf("unknown group")
+		}
+		return h, nil
+	}
+
+	cases := []struct {
+		expr     string
+		expected Hosts
+	}{
+		{"front", Hosts{"DC1": {"f1", "f2"}, "DC2": {"f3"}}},
+		{"front + back - maintenance", Hosts{"DC1": {"f1"}, "DC2": {"f3"}}},
+		{"front + back & maintenance", Hosts{"DC1": {"f1", "f2", "b1"}, "DC2": {"f3"}}},
+		{"(front + back) & dc1-only", Hosts{"DC1": {"f1", "f2", "b1"}}},
+		{"front-(maintenance)", nil},
+		{"front - (maintenance + zk:/path)", Hosts{"DC1": {"f1"}}},
+		{"front & back", Hosts{}},
+	}
+	for _, c := range cases {
+		result, err := Eval(c.expr, resolve)
+		if c.expected == nil {
+			assert.Error(t, err, c.expr)
+			continue
+		}
+		assert.NoError(t, err, c.expr)
+		assert.Equal(t, c.expected, result, c.expr)
+	}
+
+	for _, expr := range []string{"", "front +", "+ front", "(front", "front)", "front back", "unknown", "()"} {
+		_, err := Eval(expr, resolve)
+		assert.Error(t, err, expr)
+	}
+	assert.Equal(t, []string{"front", "back", "zk:/path"}, Groups("(front + back) - zk:/path"))
+}
+
+func TestLabels(t *testing.T) {
+	labels := Labels{
+		"f1": {"rack": "r1", "role": "front"},
+		"f2": {"rack": "r2", "role": "front"},
+	}
+	labels.Merge(Labels{
+		"f1": {"rack": "r9", "shard": "1"},
+		"b1": {"role": "back"},
+		"b2": {},
+	})
+	assert.Equal(t, Labels{
+		"f1": {"rack": "r1", "role": "front", "shard": "1"},
+		"f2": {"rack": "r2", "role": "front"},
+		"b1": {"role": "back"},
+	}, labels)
+
+	assert.Equal(t, Labels{"f2": {"rack": "r2", "role": "front"}, "b1": {"role": "back"}},
+		labels.Of(&Hosts{"DC1": {"f2", "b3"}, "DC2": {"b1"}}))
+
+	assert.Equal(t, map[string]string{"role": "front"}, labels.Common([]string{"f1", "f2"}))
+	assert.Equal(t, map[string]string{}, labels.Common([]string{"f1", "b1"}))
+	assert.Equal(t, map[string]string{}, labels.Common([]string{"f1", "b3"}))
+	assert.Nil(t, labels.Common(nil))
+}
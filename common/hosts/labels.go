@@ -0,0 +1,64 @@
+package hosts
+
+// Labels represent map of the host to its labels discovered along with it,
+// like rack, role, shard or version
+type Labels map[string]map[string]string
+
+// Merge labels of other hosts, labels already present are kept
+func (l Labels) Merge(other Labels) {
+	for host, labels := range other {
+		if len(labels) == 0 {
+			continue
+		}
+		current, ok := l[host]
+		if !ok {
+			current = make(map[string]string, len(labels))
+			l[host] = current
+		}
+		for k, v := range labels {
+			if _, ok := current[k]; !ok {
+				current[k] = v
+			}
+		}
+	}
+}
+
+// Of return labels only of hosts present in h
+func (l Labels) Of(h *Hosts) Labels {
+	result := make(Labels)
+	for _, hostsInDc := range *h {
+		for _, host := range hostsInDc {
+			if labels, ok := l[host]; ok {
+				result[host] = labels
+			}
+		}
+	}
+	return result
+}
+
+// Common return labels having the same value on all given hosts
+func (l Labels) Common(hosts []string) map[string]string {
+	if len(hosts) == 0 {
+		return nil
+	}
+	var common map[string]string
+	for idx, host := range hosts {
+		labels := l[host]
+		if idx == 0 {
+			common = make(map[string]string, len(labels))
+			for k, v := range labels {
+				common[k] = v
+			}
+			continue
+		}
+		for k, v := range common {
+			if lv, ok := labels[k]; !ok || lv != v {
+				delete(common, k)
+			}
+		}
+		if len(common) == 0 {
+			break
+		}
+	}
+	return common
+}
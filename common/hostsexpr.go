@@ -0,0 +1,132 @@
+package common
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/combaine/combaine/common/cache"
+	"github.com/combaine/combaine/common/hosts"
+	"github.com/combaine/combaine/repository"
+)
+
+// ResolveHosts return hosts of the parsing config: union of Groups or
+// evaluated HostsExpr, filtered by IncludeHosts and ExcludeHosts,
+// and labels of these hosts.
+// Failed groups of Groups are skipped with warning, but failed group
+// of HostsExpr fails the config, because skipped subtracted or intersected
+// group would silently change the result
+func ResolveHosts(cfg *repository.ParsingConfig, c *cache.TTLCache) (hosts.Hosts, hosts.Labels, error) {
+	log := logrus.WithField("source", "ResolveHosts")
+
+	include, err := compileHostsRegexps(cfg.IncludeHosts)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "include_hosts")
+	}
+	exclude, err := compileHostsRegexps(cfg.ExcludeHosts)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "exclude_hosts")
+	}
+
+	loaded := make(map[string]HostFetcher)
+	hostFetcher := func(name string) (HostFetcher, error) {
+		if f, ok := loaded[name]; ok {
+			return f, nil
+		}
+		config := cfg.HostFetcher
+		if name != "" {
+			var ok bool
+			if config, ok = cfg.HostFetchers[name]; !ok {
+				return nil, errors.Errorf("HostFetcher `%s` is not defined in HostFetchers", name)
+			}
+		}
+		f, err := LoadHostFetcherWithCache(config, c)
+		if err != nil {
+			return nil, err
+		}
+		loaded[name] = f
+		return f, nil
+	}
+
+	var result hosts.Hosts
+	labels := make(hosts.Labels)
+	if cfg.HostsExpr == "" {
+		f, err := hostFetcher("")
+		if err != nil {
+			return nil, nil, err
+		}
+		result = make(hosts.Hosts)
+		for _, item := range cfg.Groups {
+			hostsForGroup, labelsForGroup, err := FetchLabeled(f, item)
+			if err != nil {
+				log.WithFields(logrus.Fields{"error": err, "group": item}).Warn("unable to get hosts")
+				continue
+			}
+			result.Merge(&hostsForGroup)
+			labels.Merge(labelsForGroup)
+		}
+	} else {
+		result, err = hosts.Eval(cfg.HostsExpr, func(group string) (hosts.Hosts, error) {
+			name, group := SplitHostsGroup(group, cfg.HostFetchers)
+			f, err := hostFetcher(name)
+			if err != nil {
+				return nil, err
+			}
+			found, foundLabels, err := FetchLabeled(f, group)
+			if err != nil {
+				return nil, err
+			}
+			labels.Merge(foundLabels)
+			return found, nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if len(include) > 0 || len(exclude) > 0 {
+		result = result.Filter(func(host string) bool {
+			if len(include) > 0 && !matchAny(include, host) {
+				return false
+			}
+			return !matchAny(exclude, host)
+		})
+	}
+	return result, labels.Of(&result), nil
+}
+
+// SplitHostsGroup split group of hosts expression in the name of fetcher
+// from fetchers and its group, the name is empty for the default fetcher.
+// Prefix before `:` which is not a name of fetcher is a part of the group,
+// so zookeeper or etcd paths may contain `:`
+func SplitHostsGroup(group string, fetchers map[string]repository.PluginConfig) (string, string) {
+	if idx := strings.Index(group, ":"); idx > -1 {
+		if _, ok := fetchers[group[:idx]]; ok {
+			return group[:idx], group[idx+1:]
+		}
+	}
+	return "", group
+}
+
+func compileHostsRegexps(patterns []string) ([]*regexp.Regexp, error) {
+	result := make([]*regexp.Regexp, len(patterns))
+	for idx, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+		result[idx] = re
+	}
+	return result, nil
+}
+
+func matchAny(res []*regexp.Regexp, host string) bool {
+	for _, re := range res {
+		if re.MatchString(host) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package common
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/combaine/combaine/common/hosts"
+	"github.com/combaine/combaine/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveHosts(t *testing.T) {
+	predefine := repository.PluginConfig{
+		"type": "predefine",
+		"Clusters": map[string]map[string][]string{
+			"front":       {"DC1": {"front1", "front2"}, "DC2": {"front3"}},
+			"back":        {"DC1": {"back1", "front1"}},
+			"maintenance": {"DC1": {"front2"}},
+			"svc:8080":    {"DC2": {"front3"}},
+		},
+	}
+	other := repository.PluginConfig{
+		"type":     "predefine",
+		"Clusters": map[string]map[string][]string{"canary": {"DC2": {"front3"}}},
+	}
+
+	cases := []struct {
+		cfg      repository.ParsingConfig
+		expected hosts.Hosts
+		err      bool
+	}{
+		{repository.ParsingConfig{Groups: []string{"front", "back", "unknown"}},
+			hosts.Hosts{"DC1": {"back1", "front1", "front2"}, "DC2": {"front3"}}, false},
+		{repository.ParsingConfig{HostsExpr: "front + back - maintenance - other:canary"},
+			hosts.Hosts{"DC1": {"back1", "front1"}}, false},
+		{repository.ParsingConfig{HostsExpr: "front & back"}, hosts.Hosts{"DC1": {"front1"}}, false},
+		// prefix which is not a fetcher name is a part of the group
+		{repository.ParsingConfig{HostsExpr: "front - svc:8080"}, hosts.Hosts{"DC1": {"front1", "front2"}}, false},
+		{repository.ParsingConfig{HostsExpr: "front + back", IncludeHosts: []string{"^front"},
+			ExcludeHosts: []string{"3$"}}, hosts.Hosts{"DC1": {"front1", "front2"}}, false},
+		{repository.ParsingConfig{HostsExpr: "front - unknown"}, nil, true},
+		{repository.ParsingConfig{HostsExpr: "front - missing:canary"}, nil, true},
+		{repository.ParsingConfig{Groups: []string{"front"}, IncludeHosts: []string{"("}}, nil, true},
+	}
+	for _, c := range cases {
+		c.cfg.HostFetcher = predefine
+		c.cfg.HostFetchers = map[string]repository.PluginConfig{"other": other}
+		result, _, err := ResolveHosts(&c.cfg, nil)
+		if c.err {
+			assert.Error(t, err, c.cfg)
+			continue
+		}
+		assert.NoError(t, err, c.cfg)
+		for _, v := range result {
+			sort.Strings(v)
+		}
+		assert.Equal(t, c.expected, result, c.cfg)
+	}
+}
+
+func TestSplitHostsGroup(t *testing.T) {
+	fetchers := map[string]repository.PluginConfig{"zk": {"type": "zookeeper"}}
+	name, group := SplitHostsGroup("zk:/services/front", fetchers)
+	assert.Equal(t, "zk", name)
+	assert.Equal(t, "/services/front", group)
+	name, group = SplitHostsGroup("/services/host:8080", fetchers)
+	assert.Equal(t, "", name)
+	assert.Equal(t, "/services/host:8080", group)
+	name, group = SplitHostsGroup("front", nil)
+	assert.Equal(t, "", name)
+	assert.Equal(t, "front", group)
+}
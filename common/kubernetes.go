@@ -0,0 +1,542 @@
+package common
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/combaine/combaine/common/cache"
+	"github.com/combaine/combaine/common/chttp"
+	"github.com/combaine/combaine/common/hosts"
+	"github.com/combaine/combaine/repository"
+)
+
+const (
+	defaultKubernetesZoneLabel = "topology.kubernetes.io/zone"
+	legacyKubernetesZoneLabel  = "failure-domain.beta.kubernetes.io/zone"
+)
+
+// kubernetesServiceAccountDir holds in-cluster credentials
+var kubernetesServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// KubernetesFetcher resolve group `namespace/service` to endpoints of the service,
+// or group `namespace/label=value,...` to pods matching the label selector.
+// Credentials are taken from Kubeconfig, from Server options or in-cluster.
+// Zones of hosts are labels of their nodes, listing nodes needs cluster-scoped
+// `list` permission on `nodes`, use SkipNodes if only namespaced access is granted
+type KubernetesFetcher struct {
+	cache *cache.TTLCache
+	// Kubeconfig path and its Context, the current context by default
+	Kubeconfig string `mapstructure:"kubeconfig"`
+	Context    string `mapstructure:"context"`
+	// Server is url of the api server used with Token or TokenFile and CAFile
+	Server    string `mapstructure:"server"`
+	Token     string `mapstructure:"token"`
+	TokenFile string `mapstructure:"token_file"`
+	CAFile    string `mapstructure:"ca_file"`
+	// Namespace is used if the group has no namespace
+	Namespace string `mapstructure:"namespace"`
+	// HostField is ip (default), hostname or node
+	HostField string `mapstructure:"host_field"`
+	// ZoneLabel of the node is the DC of hosts on it
+	ZoneLabel string `mapstructure:"zone_label"`
+	// SkipNodes disables listing of nodes, all hosts are in NoDC
+	SkipNodes   bool `mapstructure:"skip_nodes"`
+	ReadTimeout int64
+
+	client *http.Client
+}
+
+// newKubernetesFetcher return kubernetes hosts fetcher
+func newKubernetesFetcher(config repository.PluginConfig) (HostFetcher, error) {
+	var fetcher KubernetesFetcher
+	if err := mapstructure.Decode(config, &fetcher); err != nil {
+		return nil, err
+	}
+	if fetcher.ReadTimeout <= 0 {
+		fetcher.ReadTimeout = 10
+	}
+	if fetcher.ZoneLabel == "" {
+		fetcher.ZoneLabel = defaultKubernetesZoneLabel
+	}
+	switch fetcher.HostField {
+	case "":
+		fetcher.HostField = "ip"
+	case "ip", "hostname", "node":
+	default:
+		return nil, fmt.Errorf("kubernetes: unknown host_field %q", fetcher.HostField)
+	}
+
+	api, err := loadKubernetesAPI(&fetcher)
+	if err != nil {
+		return nil, errors.Wrap(err, "kubernetes")
+	}
+	if fetcher.Kubeconfig != "" || fetcher.Server == "" {
+		fetcher.Token, fetcher.TokenFile = api.token, api.tokenFile
+	}
+	fetcher.Server = strings.TrimRight(api.server, "/")
+	if fetcher.Namespace == "" {
+		fetcher.Namespace = api.namespace
+	}
+	if fetcher.Namespace == "" {
+		fetcher.Namespace = "default"
+	}
+	fetcher.client = api.client
+	return &fetcher, nil
+}
+
+// kubernetesAPI is connection to the api server
+// loaded from kubeconfig, server options or in-cluster credentials
+type kubernetesAPI struct {
+	server    string
+	token     string
+	tokenFile string
+	namespace string
+	client    *http.Client
+	// files are read to load the api, stamp is their state at loading
+	files []string
+	stamp string
+}
+
+// kubernetesAPIs are keyed by credentials options, fetchers are created
+// for each iteration, so apis are reused until their files are modified
+var kubernetesAPIs = struct {
+	sync.Mutex
+	m map[string]*kubernetesAPI
+}{m: make(map[string]*kubernetesAPI)}
+
+func loadKubernetesAPI(k *KubernetesFetcher) (*kubernetesAPI, error) {
+	key := strings.Join([]string{k.Kubeconfig, k.Context, k.Server, k.CAFile,
+		os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")}, "|")
+	kubernetesAPIs.Lock()
+	defer kubernetesAPIs.Unlock()
+	cached, ok := kubernetesAPIs.m[key]
+	if ok && chttp.FilesStamp(cached.files) == cached.stamp {
+		return cached, nil
+	}
+
+	var err error
+	api := new(kubernetesAPI)
+	tlsConfig := &tls.Config{}
+	switch {
+	case k.Kubeconfig != "":
+		err = api.loadKubeconfig(k.Kubeconfig, k.Context, tlsConfig)
+	case k.Server != "":
+		api.server = k.Server
+		err = api.loadCAFile(tlsConfig, k.CAFile)
+	default:
+		err = api.loadInCluster(tlsConfig)
+	}
+	if err != nil {
+		return nil, err
+	}
+	api.stamp = chttp.FilesStamp(api.files)
+	api.client = &http.Client{Transport: &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		TLSClientConfig:     tlsConfig,
+		TLSHandshakeTimeout: 10 * time.Second,
+		MaxIdleConns:        10,
+		IdleConnTimeout:     90 * time.Second,
+	}}
+	if ok {
+		cached.client.CloseIdleConnections()
+	}
+	kubernetesAPIs.m[key] = api
+	return api, nil
+}
+
+func (api *kubernetesAPI) loadInCluster(tlsConfig *tls.Config) error {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return errors.New("not in cluster, kubeconfig or server should be configured")
+	}
+	api.server = "https://" + net.JoinHostPort(host, port)
+	api.tokenFile = filepath.Join(kubernetesServiceAccountDir, "token")
+	namespaceFile := filepath.Join(kubernetesServiceAccountDir, "namespace")
+	api.files = append(api.files, namespaceFile)
+	if ns, err := ioutil.ReadFile(namespaceFile); err == nil {
+		api.namespace = strings.TrimSpace(string(ns))
+	}
+	return api.loadCAFile(tlsConfig, filepath.Join(kubernetesServiceAccountDir, "ca.crt"))
+}
+
+// loadCAFile load CA and remember the file
+func (api *kubernetesAPI) loadCAFile(tlsConfig *tls.Config, path string) error {
+	api.files = append(api.files, path)
+	return loadCAFile(tlsConfig, path)
+}
+
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Contexts       []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster   string `yaml:"cluster"`
+			User      string `yaml:"user"`
+			Namespace string `yaml:"namespace"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+	Clusters []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string `yaml:"token"`
+			TokenFile             string `yaml:"tokenFile"`
+			ClientCertificate     string `yaml:"client-certificate"`
+			ClientCertificateData string `yaml:"client-certificate-data"`
+			ClientKey             string `yaml:"client-key"`
+			ClientKeyData         string `yaml:"client-key-data"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+}
+
+func (api *kubernetesAPI) loadKubeconfig(path, context string, tlsConfig *tls.Config) error {
+	api.files = append(api.files, path)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var cfg kubeconfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return err
+	}
+	// relative paths in kubeconfig are relative to its location
+	resolve := func(file string) string {
+		if file == "" || filepath.IsAbs(file) {
+			return file
+		}
+		return filepath.Join(filepath.Dir(path), file)
+	}
+
+	name := context
+	if name == "" {
+		name = cfg.CurrentContext
+	}
+	var clusterName, userName string
+	found := false
+	for _, c := range cfg.Contexts {
+		if c.Name == name {
+			clusterName, userName = c.Context.Cluster, c.Context.User
+			api.namespace = c.Context.Namespace
+			found = true
+		}
+	}
+	if !found {
+		return errors.Errorf("context %q not found in %s", name, path)
+	}
+
+	found = false
+	for _, c := range cfg.Clusters {
+		if c.Name != clusterName {
+			continue
+		}
+		found = true
+		api.server = c.Cluster.Server
+		tlsConfig.InsecureSkipVerify = c.Cluster.InsecureSkipTLSVerify
+		if c.Cluster.CertificateAuthorityData != "" {
+			ca, err := base64.StdEncoding.DecodeString(c.Cluster.CertificateAuthorityData)
+			if err != nil {
+				return errors.Wrap(err, "certificate-authority-data")
+			}
+			if err := appendCA(tlsConfig, ca); err != nil {
+				return err
+			}
+		} else if err := api.loadCAFile(tlsConfig, resolve(c.Cluster.CertificateAuthority)); err != nil {
+			return err
+		}
+	}
+	if !found {
+		return errors.Errorf("cluster %q not found in %s", clusterName, path)
+	}
+
+	for _, u := range cfg.Users {
+		if u.Name != userName {
+			continue
+		}
+		api.token = u.User.Token
+		api.tokenFile = resolve(u.User.TokenFile)
+
+		certPEM, keyPEM := []byte(nil), []byte(nil)
+		if u.User.ClientCertificateData != "" {
+			if certPEM, err = base64.StdEncoding.DecodeString(u.User.ClientCertificateData); err != nil {
+				return errors.Wrap(err, "client-certificate-data")
+			}
+		} else if u.User.ClientCertificate != "" {
+			api.files = append(api.files, resolve(u.User.ClientCertificate))
+			if certPEM, err = ioutil.ReadFile(resolve(u.User.ClientCertificate)); err != nil {
+				return err
+			}
+		}
+		if u.User.ClientKeyData != "" {
+			if keyPEM, err = base64.StdEncoding.DecodeString(u.User.ClientKeyData); err != nil {
+				return errors.Wrap(err, "client-key-data")
+			}
+		} else if u.User.ClientKey != "" {
+			api.files = append(api.files, resolve(u.User.ClientKey))
+			if keyPEM, err = ioutil.ReadFile(resolve(u.User.ClientKey)); err != nil {
+				return err
+			}
+		}
+		if certPEM != nil || keyPEM != nil {
+			cert, err := tls.X509KeyPair(certPEM, keyPEM)
+			if err != nil {
+				return errors.Wrap(err, "client certificate")
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+	}
+	return nil
+}
+
+func loadCAFile(tlsConfig *tls.Config, path string) error {
+	if path == "" {
+		return nil
+	}
+	ca, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return appendCA(tlsConfig, ca)
+}
+
+func appendCA(tlsConfig *tls.Config, ca []byte) error {
+	if tlsConfig.RootCAs == nil {
+		tlsConfig.RootCAs = x509.NewCertPool()
+	}
+	if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+		return errors.New("no certificates found in CA")
+	}
+	return nil
+}
+
+type kubernetesObjectMeta struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels"`
+}
+
+type kubernetesEndpoints struct {
+	Subsets []struct {
+		Addresses []struct {
+			IP        string `json:"ip"`
+			Hostname  string `json:"hostname"`
+			NodeName  string `json:"nodeName"`
+			TargetRef struct {
+				Name string `json:"name"`
+			} `json:"targetRef"`
+		} `json:"addresses"`
+	} `json:"subsets"`
+}
+
+type kubernetesPodList struct {
+	Items []struct {
+		Metadata kubernetesObjectMeta `json:"metadata"`
+		Spec     struct {
+			NodeName string `json:"nodeName"`
+		} `json:"spec"`
+		Status struct {
+			Phase string `json:"phase"`
+			PodIP string `json:"podIP"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+type kubernetesNodeList struct {
+	Items []struct {
+		Metadata kubernetesObjectMeta `json:"metadata"`
+	} `json:"items"`
+}
+
+// kubernetesTarget is a found pod
+type kubernetesTarget struct {
+	ip, hostname, node string
+	labels             map[string]string
+}
+
+// Fetch resolve the group in the list of hosts
+func (k *KubernetesFetcher) Fetch(group string) (hosts.Hosts, error) {
+	response, _, err := k.FetchLabeled(group)
+	return response, err
+}
+
+// FetchLabeled resolve the group in the list of hosts, hosts are labeled
+// with the node name and with pod labels if pods are found by selector
+func (k *KubernetesFetcher) FetchLabeled(group string) (hosts.Hosts, hosts.Labels, error) {
+	namespace, name := k.Namespace, group
+	if idx := strings.Index(group, "/"); idx > -1 {
+		namespace, name = group[:idx], group[idx+1:]
+	}
+	if namespace == "" || name == "" {
+		return nil, nil, errors.Errorf("kubernetes: bad group %q, namespace/service or namespace/selector expected", group)
+	}
+
+	var targets []kubernetesTarget
+	if strings.ContainsAny(name, "=!") || strings.Contains(name, " in ") {
+		var pods kubernetesPodList
+		path := fmt.Sprintf("/api/v1/namespaces/%s/pods?labelSelector=%s",
+			url.PathEscape(namespace), url.QueryEscape(name))
+		if err := k.get(group, path, &pods); err != nil {
+			return nil, nil, err
+		}
+		for _, p := range pods.Items {
+			if p.Status.Phase != "Running" || p.Status.PodIP == "" {
+				continue
+			}
+			targets = append(targets, kubernetesTarget{
+				ip: p.Status.PodIP, hostname: p.Metadata.Name, node: p.Spec.NodeName, labels: p.Metadata.Labels})
+		}
+	} else {
+		var endpoints kubernetesEndpoints
+		path := fmt.Sprintf("/api/v1/namespaces/%s/endpoints/%s",
+			url.PathEscape(namespace), url.PathEscape(name))
+		if err := k.get(group, path, &endpoints); err != nil {
+			return nil, nil, err
+		}
+		for _, s := range endpoints.Subsets {
+			for _, a := range s.Addresses {
+				hostname := a.Hostname
+				if hostname == "" {
+					hostname = a.TargetRef.Name
+				}
+				targets = append(targets, kubernetesTarget{ip: a.IP, hostname: hostname, node: a.NodeName})
+			}
+		}
+	}
+	if len(targets) == 0 {
+		return nil, nil, ErrNoHosts
+	}
+
+	var nodes kubernetesNodeList
+	if !k.SkipNodes {
+		if err := k.get(group, "/api/v1/nodes", &nodes); err != nil {
+			logrus.WithField("source", "KubernetesFetcher").Errorf(
+				"Unable to list nodes, hosts are in NoDC (skip_nodes disables listing): %s", err)
+		}
+	}
+	zones := make(map[string]string, len(nodes.Items))
+	for _, n := range nodes.Items {
+		zone, ok := n.Metadata.Labels[k.ZoneLabel]
+		if !ok {
+			zone = n.Metadata.Labels[legacyKubernetesZoneLabel]
+		}
+		zones[n.Metadata.Name] = zone
+	}
+
+	response := make(hosts.Hosts)
+	labels := make(hosts.Labels)
+	for _, t := range targets {
+		host := t.ip
+		switch k.HostField {
+		case "hostname":
+			host = t.hostname
+		case "node":
+			host = t.node
+		}
+		if host == "" {
+			continue
+		}
+		dc := zones[t.node]
+		if dc == "" {
+			dc = "NoDC"
+		}
+		response[dc] = append(response[dc], host)
+		hostLabels := make(map[string]string, len(t.labels)+1)
+		for name, value := range t.labels {
+			hostLabels[name] = value
+		}
+		if t.node != "" {
+			hostLabels["node"] = t.node
+		}
+		if len(hostLabels) > 0 {
+			labels[host] = hostLabels
+		}
+	}
+	if len(response) == 0 {
+		return response, labels, ErrNoHosts
+	}
+	return response, labels, nil
+}
+
+func (k *KubernetesFetcher) get(id, path string, result interface{}) error {
+	log := logrus.WithField("source", "KubernetesFetcher")
+	apiURL := k.Server + path
+
+	fetcher := func() ([]byte, error) {
+		ctx, cancel := context.WithTimeout(
+			context.Background(), time.Duration(k.ReadTimeout)*time.Second,
+		)
+		defer cancel()
+		req, err := http.NewRequest("GET", apiURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		token := k.Token
+		if k.TokenFile != "" {
+			// service account tokens are rotated
+			data, err := ioutil.ReadFile(k.TokenFile)
+			if err != nil {
+				return nil, err
+			}
+			token = strings.TrimSpace(string(data))
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		req.Header.Set("Accept", "application/json")
+		resp, err := chttp.DoWithClient(ctx, k.client, req)
+		if err != nil {
+			log.Errorf("Unable to fetch %s: %s", apiURL, err)
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			err = errors.Errorf("%s answered with %s", apiURL, resp.Status)
+			log.Error(err)
+			return nil, err
+		}
+		return ioutil.ReadAll(resp.Body)
+	}
+
+	var body []byte
+	var err error
+	if k.cache != nil {
+		body, err = k.cache.GetBytes(id, apiURL, fetcher)
+	} else {
+		body, err = fetcher()
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, result); err != nil {
+		return fmt.Errorf("Failed to parse json body: %s", err)
+	}
+	return nil
+}
+
+func (k *KubernetesFetcher) setCache(c *cache.TTLCache) {
+	k.cache = c
+}
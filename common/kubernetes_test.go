@@ -0,0 +1,158 @@
+package common
+
+import (
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/combaine/combaine/common/hosts"
+	"github.com/combaine/combaine/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func fakeKubernetesAPI(t *testing.T) *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v1/namespaces/prod/endpoints/front":
+			fmt.Fprint(w, `{"subsets": [{"addresses": [
+{"ip": "10.0.0.1", "nodeName": "node1", "targetRef": {"kind": "Pod", "name": "front-1"}},
+{"ip": "10.0.0.2", "hostname": "front-2", "nodeName": "node2"},
+{"ip": "10.0.0.3", "nodeName": "node3", "targetRef": {"kind": "Pod", "name": "front-3"}}]}]}`)
+		case "/api/v1/namespaces/prod/endpoints/empty":
+			fmt.Fprint(w, `{"subsets": []}`)
+		case "/api/v1/namespaces/prod/pods":
+			assert.Equal(t, "app=back,tier!=canary", r.URL.Query().Get("labelSelector"))
+			fmt.Fprint(w, `{"items": [
+{"metadata": {"name": "back-1", "labels": {"app": "back", "version": "1.2"}}, "spec": {"nodeName": "node1"}, "status": {"phase": "Running", "podIP": "10.0.1.1"}},
+{"metadata": {"name": "back-2"}, "spec": {"nodeName": "node2"}, "status": {"phase": "Pending"}}]}`)
+		case "/api/v1/nodes":
+			fmt.Fprint(w, `{"items": [
+{"metadata": {"name": "node1", "labels": {"topology.kubernetes.io/zone": "zone-a"}}},
+{"metadata": {"name": "node2", "labels": {"failure-domain.beta.kubernetes.io/zone": "zone-b"}}},
+{"metadata": {"name": "node3", "labels": {}}}]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestKubernetesFetcher(t *testing.T) {
+	ts := fakeKubernetesAPI(t)
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "combaine-kubernetes")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "ca.crt"), caPEM, 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "token"), []byte("secret\n"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "namespace"), []byte("prod"), 0644))
+	kubeconfig := fmt.Sprintf(`
+current-context: test
+contexts:
+- name: test
+  context: {cluster: fake, user: robot, namespace: prod}
+- name: anonymous
+  context: {cluster: fake, user: nobody}
+clusters:
+- name: fake
+  cluster:
+    server: %s
+    certificate-authority-data: %s
+users:
+- name: robot
+  user: {token: secret}
+`, ts.URL, base64.StdEncoding.EncodeToString(caPEM))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "kubeconfig"), []byte(kubeconfig), 0644))
+
+	host, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+	os.Setenv("KUBERNETES_SERVICE_HOST", host)
+	os.Setenv("KUBERNETES_SERVICE_PORT", port)
+	defer os.Unsetenv("KUBERNETES_SERVICE_HOST")
+	defer os.Unsetenv("KUBERNETES_SERVICE_PORT")
+	kubernetesServiceAccountDir = dir
+
+	cases := []struct {
+		config   repository.PluginConfig
+		group    string
+		expected hosts.Hosts
+		err      bool
+	}{
+		{repository.PluginConfig{"kubeconfig": filepath.Join(dir, "kubeconfig")}, "front",
+			hosts.Hosts{"zone-a": {"10.0.0.1"}, "zone-b": {"10.0.0.2"}, "NoDC": {"10.0.0.3"}}, false},
+		{repository.PluginConfig{"server": ts.URL, "token": "secret", "ca_file": filepath.Join(dir, "ca.crt"),
+			"host_field": "hostname"}, "prod/front",
+			hosts.Hosts{"zone-a": {"front-1"}, "zone-b": {"front-2"}, "NoDC": {"front-3"}}, false},
+		{repository.PluginConfig{"host_field": "node"}, "prod/app=back,tier!=canary",
+			hosts.Hosts{"zone-a": {"node1"}}, false},
+		{repository.PluginConfig{"kubeconfig": filepath.Join(dir, "kubeconfig"), "skip_nodes": true}, "front",
+			hosts.Hosts{"NoDC": {"10.0.0.1", "10.0.0.2", "10.0.0.3"}}, false},
+		{repository.PluginConfig{}, "empty", nil, true},
+		{repository.PluginConfig{}, "prod/unknown", nil, true},
+		{repository.PluginConfig{"kubeconfig": filepath.Join(dir, "kubeconfig"), "context": "anonymous"},
+			"prod/front", nil, true},
+	}
+	for _, c := range cases {
+		c.config["type"] = "kubernetes"
+		f, err := LoadHostFetcher(c.config)
+		assert.NoError(t, err, c.config)
+		found, err := f.Fetch(c.group)
+		if c.err {
+			assert.Error(t, err, c.config)
+			continue
+		}
+		assert.NoError(t, err, c.config)
+		for _, v := range found {
+			sort.Strings(v)
+		}
+		assert.Equal(t, c.expected, found, c.config)
+	}
+
+	f, err := LoadHostFetcher(repository.PluginConfig{"type": "kubernetes"})
+	assert.NoError(t, err)
+	_, labels, err := FetchLabeled(f, "prod/app=back,tier!=canary")
+	assert.NoError(t, err)
+	assert.Equal(t, hosts.Labels{"10.0.1.1": {"app": "back", "version": "1.2", "node": "node1"}}, labels)
+
+	// client is reused until kubeconfig is modified
+	kubeconfigPath := filepath.Join(dir, "kubeconfig")
+	first, err := LoadHostFetcher(repository.PluginConfig{"type": "kubernetes", "kubeconfig": kubeconfigPath})
+	assert.NoError(t, err)
+	second, err := LoadHostFetcher(repository.PluginConfig{"type": "kubernetes", "kubeconfig": kubeconfigPath})
+	assert.NoError(t, err)
+	assert.True(t, first.(*KubernetesFetcher).client == second.(*KubernetesFetcher).client)
+	assert.NoError(t, ioutil.WriteFile(kubeconfigPath, []byte(kubeconfig+"\n"), 0644))
+	third, err := LoadHostFetcher(repository.PluginConfig{"type": "kubernetes", "kubeconfig": kubeconfigPath})
+	assert.NoError(t, err)
+	assert.False(t, first.(*KubernetesFetcher).client == third.(*KubernetesFetcher).client)
+	_, err = third.Fetch("front")
+	assert.NoError(t, err)
+
+	badConfigs := []repository.PluginConfig{
+		{"host_field": "pod"},
+		{"kubeconfig": filepath.Join(dir, "missing")},
+		{"kubeconfig": filepath.Join(dir, "kubeconfig"), "context": "missing"},
+		{"server": ts.URL, "ca_file": filepath.Join(dir, "token")},
+	}
+	for _, c := range badConfigs {
+		c["type"] = "kubernetes"
+		_, err := LoadHostFetcher(c)
+		assert.Error(t, err, c)
+	}
+
+	os.Unsetenv("KUBERNETES_SERVICE_HOST")
+	_, err = LoadHostFetcher(repository.PluginConfig{"type": "kubernetes"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,326 @@
+package common
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
+	"github.com/samuel/go-zookeeper/zk"
+	"github.com/sirupsen/logrus"
+
+	"github.com/combaine/combaine/common/cache"
+	"github.com/combaine/combaine/common/hosts"
+	"github.com/combaine/combaine/repository"
+)
+
+// zkUnknownDC is datacenter of hosts without dc in node data
+const zkUnknownDC = "unk"
+
+var (
+	// zkRetryInterval is delay before watching node again after error
+	zkRetryInterval = 5 * time.Second
+	// zkIdleTimeout is time after which unused watch is removed
+	zkIdleTimeout = 30 * time.Minute
+	// zkRefreshInterval is period of reading data of watched children,
+	// only the list of children is watched
+	zkRefreshInterval = time.Minute
+)
+
+// ZKFetcher recive hosts from children of ZK node, children are watched
+// in long-lived session, so the cache is updated without listing the node.
+// Data of children is re-read each zkRefreshInterval
+type ZKFetcher struct {
+	cache     *cache.TTLCache
+	Servers   []string `mapstructure:"servers"`
+	StripPort bool     `mapstructure:"strip_port"`
+	// Chroot is prefix of all paths
+	Chroot string `mapstructure:"chroot"`
+	// Digest is `user:password` for digest auth
+	Digest         string `mapstructure:"digest"`
+	SessionTimeout int64
+	// DCField is field in json data of child node with datacenter
+	DCField string `mapstructure:"dc_field"`
+	// HostField is field in json data of child node with hostname,
+	// name of the child node is used by default
+	HostField string `mapstructure:"host_field"`
+	// Labels are fields in json data of child node copied to labels,
+	// all scalar fields are copied by default
+	Labels []string `mapstructure:"labels"`
+
+	timeout time.Duration
+}
+
+// newZKFetcher return list of hosts fetched from zk discovery service
+func newZKFetcher(config repository.PluginConfig) (HostFetcher, error) {
+	var fetcher ZKFetcher
+	if err := mapstructure.Decode(config, &fetcher); err != nil {
+		return nil, err
+	}
+	if len(fetcher.Servers) == 0 {
+		return nil, errors.New("zk: servers are not specified")
+	}
+	if fetcher.Chroot != "" {
+		fetcher.Chroot = path.Clean("/" + fetcher.Chroot)
+	}
+	if fetcher.DCField == "" {
+		fetcher.DCField = "dc"
+	}
+	if fetcher.SessionTimeout <= 0 {
+		fetcher.SessionTimeout = 10
+	}
+	fetcher.timeout = time.Duration(fetcher.SessionTimeout) * time.Second
+	return &fetcher, nil
+}
+
+// Fetch hosts from zk node
+func (z *ZKFetcher) Fetch(nodePath string) (hosts.Hosts, error) {
+	response, _, err := z.FetchLabeled(nodePath)
+	return response, err
+}
+
+// FetchLabeled hosts from zk node, hosts are labeled with fields of node data
+func (z *ZKFetcher) FetchLabeled(nodePath string) (hosts.Hosts, hosts.Labels, error) {
+	fullPath := path.Join("/", z.Chroot, nodePath)
+	fetcher := func() (map[string][]string, map[string]map[string]string, error) {
+		return z.list(fullPath)
+	}
+	if z.cache != nil {
+		key := fmt.Sprintf("zk:%s:%s|host_field=%s|dc_field=%s|labels=%s|strip_port=%t",
+			strings.Join(z.Servers, ","), fullPath, z.HostField, z.DCField, strings.Join(z.Labels, ","), z.StripPort)
+		return z.cache.GetLabeledHosts(nodePath, key, fetcher)
+	}
+	return fetcher()
+}
+
+// list return hosts of watched children of the node
+func (z *ZKFetcher) list(fullPath string) (hosts.Hosts, hosts.Labels, error) {
+	session, err := getZKSession(z.Servers, z.Digest, z.timeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	w := session.watch(fullPath)
+	select {
+	case <-w.ready:
+	case <-time.After(z.timeout):
+		return nil, nil, errors.Errorf("zk: timeout while listing %s", fullPath)
+	}
+	nodes, err := w.get()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	response := make(hosts.Hosts)
+	labels := make(hosts.Labels)
+	for name, data := range nodes {
+		host, dc, hostLabels := z.parseNode(name, data)
+		response[dc] = append(response[dc], host)
+		if len(hostLabels) > 0 {
+			labels[host] = hostLabels
+		}
+	}
+	if len(response) == 0 {
+		return response, labels, ErrNoHosts
+	}
+	return response, labels, nil
+}
+
+// parseNode return host, its datacenter and labels from child node
+func (z *ZKFetcher) parseNode(name string, data []byte) (string, string, map[string]string) {
+	host, dc := name, zkUnknownDC
+	var labels map[string]string
+	if len(data) > 0 {
+		if h, d, l, err := parseHostData(data, z.HostField, z.DCField, z.Labels); err == nil {
+			if h != "" {
+				host = h
+			}
+			if d != "" {
+				dc = d
+			}
+			labels = l
+		}
+	}
+	if z.StripPort {
+		if delimIdx := strings.LastIndex(host, ":"); delimIdx > -1 {
+			host = host[:delimIdx]
+		}
+	}
+	return host, dc, labels
+}
+
+func (z *ZKFetcher) setCache(c *cache.TTLCache) {
+	z.cache = c
+}
+
+// zkConn is used part of zk.Conn
+type zkConn interface {
+	ChildrenW(path string) ([]string, *zk.Stat, <-chan zk.Event, error)
+	Get(path string) ([]byte, *zk.Stat, error)
+}
+
+// zkConnect create new zk session
+var zkConnect = func(servers []string, timeout time.Duration, digest string) (zkConn, error) {
+	conn, _, err := zk.Connect(servers, timeout, zk.WithLogger(logrus.WithField("source", "ZKFetcher")))
+	if err != nil {
+		return nil, err
+	}
+	if digest != "" {
+		if err := conn.AddAuth("digest", []byte(digest)); err != nil {
+			conn.Close()
+			return nil, errors.Wrap(err, "zk: auth")
+		}
+	}
+	return conn, nil
+}
+
+// zkSession is long-lived zk session with watched nodes
+type zkSession struct {
+	sync.Mutex
+	conn    zkConn
+	watches map[string]*zkWatch
+}
+
+// zkSessions are shared between fetchers with the same servers and auth
+var zkSessions = struct {
+	sync.Mutex
+	m map[string]*zkSession
+}{m: make(map[string]*zkSession)}
+
+func getZKSession(servers []string, digest string, timeout time.Duration) (*zkSession, error) {
+	key := fmt.Sprintf("%s|%s", strings.Join(servers, ","), digest)
+	zkSessions.Lock()
+	defer zkSessions.Unlock()
+	if s, ok := zkSessions.m[key]; ok {
+		return s, nil
+	}
+	conn, err := zkConnect(servers, timeout, digest)
+	if err != nil {
+		return nil, err
+	}
+	s := &zkSession{conn: conn, watches: make(map[string]*zkWatch)}
+	zkSessions.m[key] = s
+	return s, nil
+}
+
+// zkWatch is the last listed children of the node with their data
+type zkWatch struct {
+	sync.Mutex
+	nodes    map[string][]byte
+	err      error
+	accessed time.Time
+	ready    chan struct{}
+	once     sync.Once
+}
+
+// watch return watch of the node, watching is started if needed
+func (s *zkSession) watch(nodePath string) *zkWatch {
+	s.Lock()
+	defer s.Unlock()
+	w, ok := s.watches[nodePath]
+	if !ok {
+		w = &zkWatch{ready: make(chan struct{})}
+		s.watches[nodePath] = w
+		go s.run(nodePath, w)
+	}
+	w.Lock()
+	w.accessed = time.Now()
+	w.Unlock()
+	return w
+}
+
+// run list children of the node each time they change and read their data
+// each zkRefreshInterval, until the watch is not used for zkIdleTimeout
+func (s *zkSession) run(nodePath string, w *zkWatch) {
+	log := logrus.WithField("source", "ZKFetcher")
+	idle := time.NewTicker(zkIdleTimeout / 2)
+	defer idle.Stop()
+	refresh := time.NewTicker(zkRefreshInterval)
+	defer refresh.Stop()
+
+	for {
+		children, _, events, err := s.conn.ChildrenW(nodePath)
+		var retry <-chan time.Time
+		if err != nil {
+			log.Errorf("Unable to list %s: %s", nodePath, err)
+			retry = time.After(zkRetryInterval)
+			w.set(nil, err)
+		} else {
+			w.set(s.read(nodePath, children), nil)
+		}
+
+	wait:
+		for {
+			select {
+			case ev := <-events:
+				if ev.Err != nil {
+					log.Warnf("Watch of %s failed: %s", nodePath, ev.Err)
+				}
+				break wait
+			case <-retry:
+				break wait
+			case <-refresh.C:
+				if retry == nil {
+					// data of children is not watched, the children watch
+					// is still pending, so it is not set again
+					w.set(s.read(nodePath, children), nil)
+				}
+			case <-idle.C:
+				if s.dropIdle(nodePath, w) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// read data of children, removed children are skipped
+func (s *zkSession) read(nodePath string, children []string) map[string][]byte {
+	log := logrus.WithField("source", "ZKFetcher")
+	nodes := make(map[string][]byte, len(children))
+	for _, child := range children {
+		childPath := path.Join(nodePath, child)
+		data, _, err := s.conn.Get(childPath)
+		if err != nil && err != zk.ErrNoNode {
+			log.Errorf("Unable to get data of %s: %s", childPath, err)
+		}
+		if err != zk.ErrNoNode {
+			nodes[child] = data
+		}
+	}
+	return nodes
+}
+
+// dropIdle remove the watch if it is not used for zkIdleTimeout
+func (s *zkSession) dropIdle(nodePath string, w *zkWatch) bool {
+	s.Lock()
+	defer s.Unlock()
+	w.Lock()
+	defer w.Unlock()
+	if time.Since(w.accessed) < zkIdleTimeout {
+		return false
+	}
+	delete(s.watches, nodePath)
+	return true
+}
+
+// set result of listing, on error the previous children are kept
+// unless the node is removed
+func (w *zkWatch) set(nodes map[string][]byte, err error) {
+	w.Lock()
+	if err == nil || err == zk.ErrNoNode {
+		w.nodes, w.err = nodes, err
+	} else if w.nodes == nil {
+		w.err = err
+	}
+	w.Unlock()
+	w.once.Do(func() { close(w.ready) })
+}
+
+func (w *zkWatch) get() (map[string][]byte, error) {
+	w.Lock()
+	defer w.Unlock()
+	return w.nodes, w.err
+}
@@ -0,0 +1,220 @@
+package common
+
+import (
+	"errors"
+	"path"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/combaine/combaine/common/cache"
+	"github.com/combaine/combaine/common/hosts"
+	"github.com/combaine/combaine/repository"
+)
+
+type fakeZK struct {
+	sync.Mutex
+	nodes    map[string]map[string][]byte
+	err      error
+	watchers map[string][]chan zk.Event
+	lists    int
+}
+
+func (f *fakeZK) ChildrenW(path string) ([]string, *zk.Stat, <-chan zk.Event, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.lists++
+	if f.err != nil {
+		return nil, nil, nil, f.err
+	}
+	children, ok := f.nodes[path]
+	if !ok {
+		return nil, nil, nil, zk.ErrNoNode
+	}
+	var names []string
+	for name := range children {
+		names = append(names, name)
+	}
+	ch := make(chan zk.Event, 1)
+	f.watchers[path] = append(f.watchers[path], ch)
+	return names, nil, ch, nil
+}
+
+func (f *fakeZK) Get(nodePath string) ([]byte, *zk.Stat, error) {
+	f.Lock()
+	defer f.Unlock()
+	data, ok := f.nodes[path.Dir(nodePath)][path.Base(nodePath)]
+	if !ok {
+		return nil, nil, zk.ErrNoNode
+	}
+	return data, nil, nil
+}
+
+// remove the node and notify its watchers
+func (f *fakeZK) remove(path string) {
+	f.Lock()
+	defer f.Unlock()
+	delete(f.nodes, path)
+	for _, ch := range f.watchers[path] {
+		ch <- zk.Event{Type: zk.EventNodeDeleted, Path: path}
+	}
+	f.watchers[path] = nil
+}
+
+func (f *fakeZK) update(path string, children map[string][]byte) {
+	f.Lock()
+	defer f.Unlock()
+	f.nodes[path] = children
+	for _, ch := range f.watchers[path] {
+		ch <- zk.Event{Type: zk.EventNodeChildrenChanged, Path: path}
+	}
+	f.watchers[path] = nil
+}
+
+// waitFor return true if cond becomes true within a second
+func waitFor(cond func() bool) bool {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); {
+		if cond() {
+			return true
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return false
+}
+
+func TestZKFetcher(t *testing.T) {
+	fake := &fakeZK{
+		nodes: map[string]map[string][]byte{
+			"/root/services/front": {
+				"front1:8080": []byte(`{"dc": "dc1", "shard": 1, "role": "front", "meta": {"a": "b"}}`),
+				"front2:8080": []byte(`not json`),
+				"front3:8080": nil,
+			},
+			"/services/back": {
+				"b1": []byte(`{"host": "back1.example.com", "dc": "dc2", "shard": "2"}`),
+			},
+			"/services/empty": {},
+			"/":               {"root1": nil},
+		},
+		watchers: make(map[string][]chan zk.Event),
+	}
+	var connected []string
+	defer func(connect func([]string, time.Duration, string) (zkConn, error)) {
+		zkConnect = connect
+	}(zkConnect)
+	zkSessions.m = make(map[string]*zkSession)
+	zkConnect = func(servers []string, timeout time.Duration, digest string) (zkConn, error) {
+		connected = append(connected, digest)
+		return fake, nil
+	}
+	if zkRetryInterval != 10*time.Millisecond {
+		// watches of the previous runs are still alive
+		zkRetryInterval = 10 * time.Millisecond
+		zkRefreshInterval = 10 * time.Millisecond
+	}
+
+	_, err := LoadHostFetcher(repository.PluginConfig{"type": "zk"})
+	assert.Error(t, err)
+
+	f, err := LoadHostFetcher(repository.PluginConfig{
+		"type": "zk", "servers": []string{"zk1:2181"}, "chroot": "root", "strip_port": true, "digest": "user:secret",
+	})
+	assert.NoError(t, err)
+	found, labels, err := FetchLabeled(f, "services/front")
+	assert.NoError(t, err)
+	sort.Strings(found["unk"])
+	assert.Equal(t, hosts.Hosts{"dc1": {"front1"}, "unk": {"front2", "front3"}}, found)
+	assert.Equal(t, hosts.Labels{"front1": {"shard": "1", "role": "front"}}, labels)
+
+	f, err = LoadHostFetcher(repository.PluginConfig{
+		"type": "zk", "servers": []string{"zk1:2181"}, "host_field": "host", "labels": []string{"shard", "rack"},
+	})
+	assert.NoError(t, err)
+	found, labels, err = FetchLabeled(f, "/services/back")
+	assert.NoError(t, err)
+	assert.Equal(t, hosts.Hosts{"dc2": {"back1.example.com"}}, found)
+	assert.Equal(t, hosts.Labels{"back1.example.com": {"shard": "2"}}, labels)
+
+	_, err = f.Fetch("/services/empty")
+	assert.Equal(t, ErrNoHosts, err)
+	_, err = f.Fetch("/services/unknown")
+	assert.Error(t, err)
+
+	// sessions are shared between fetchers with the same servers and auth
+	assert.Equal(t, []string{"user:secret", ""}, connected)
+
+	// children are updated by watch
+	fake.update("/services/back", map[string][]byte{
+		"b1": []byte(`{"host": "back1.example.com", "dc": "dc2"}`),
+		"b2": []byte(`{"host": "back2.example.com", "dc": "dc2"}`),
+	})
+	assert.True(t, waitFor(func() bool {
+		found, err = f.Fetch("/services/back")
+		return err == nil && len(found["dc2"]) == 2
+	}), "watch update")
+
+	// the last children are kept while zk is unavailable
+	fake.Lock()
+	fake.err = errors.New("connection lost")
+	lists := fake.lists
+	fake.Unlock()
+	fake.update("/services/back", nil)
+	assert.True(t, waitFor(func() bool {
+		fake.Lock()
+		defer fake.Unlock()
+		return fake.lists > lists+1
+	}), "retry after error")
+	found, err = f.Fetch("/services/back")
+	assert.NoError(t, err)
+	assert.Len(t, found["dc2"], 2)
+
+	// data of children is re-read without children changes
+	fake.Lock()
+	fake.err = nil
+	fake.nodes["/services/back"] = map[string][]byte{"b1": []byte(`{"host": "back1.example.com", "dc": "dc3"}`)}
+	fake.Unlock()
+	assert.True(t, waitFor(func() bool {
+		found, err = f.Fetch("/services/back")
+		return err == nil && len(found["dc3"]) == 1
+	}), "data refresh")
+	// the pending children watch is not set again on refresh
+	time.Sleep(5 * zkRefreshInterval)
+	fake.Lock()
+	assert.Len(t, fake.watchers["/services/back"], 1)
+	fake.Unlock()
+
+	// children of the root
+	found, err = f.Fetch("/")
+	assert.NoError(t, err)
+	assert.Equal(t, hosts.Hosts{"unk": {"root1"}}, found)
+
+	// hosts are cached, the last good value is served when the node fails
+	ttlCache := cache.NewCache(time.Millisecond, time.Minute, time.Minute)
+	ttlCache.SetMaxStale(time.Minute)
+	cf, err := LoadHostFetcherWithCache(repository.PluginConfig{
+		"type": "zk", "servers": []string{"zk1:2181"}, "host_field": "host",
+	}, ttlCache)
+	assert.NoError(t, err)
+	found, err = cf.Fetch("/services/back")
+	assert.NoError(t, err)
+	assert.Equal(t, hosts.Hosts{"dc3": {"back1.example.com"}}, found)
+	assert.Len(t, ttlCache.Entries(), 1)
+
+	// removed node is not served
+	fake.remove("/services/back")
+	assert.True(t, waitFor(func() bool {
+		_, err = f.Fetch("/services/back")
+		return err == zk.ErrNoNode
+	}), "removed node")
+	ttlCache.Invalidate(ttlCache.Entries()[0].Key)
+	for i := 0; i < 3; i++ {
+		found, err = cf.Fetch("/services/back")
+		assert.NoError(t, err)
+		assert.Equal(t, hosts.Hosts{"dc3": {"back1.example.com"}}, found)
+		time.Sleep(5 * time.Millisecond)
+	}
+}
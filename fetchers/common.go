@@ -1,11 +1,10 @@
 package fetchers
 
 import (
-	"github.com/combaine/combaine/repository"
 	"github.com/mitchellh/mapstructure"
 )
 
-func decodeConfig(cfg repository.PluginConfig, result interface{}) error {
+func decodeConfig(cfg interface{}, result interface{}) error {
 	decoderConfig := mapstructure.DecoderConfig{
 		WeaklyTypedInput: true, // To allow decoder parses []uint8 as string
 		Result:           result,
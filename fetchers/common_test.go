@@ -1,16 +1,27 @@
 package fetchers
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/pem"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/combaine/combaine/repository"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -203,3 +214,693 @@ func TestTimetailFetcherFetch(t *testing.T) {
 		}
 	}
 }
+
+func TestFileFetcherConfig(t *testing.T) {
+	var plainConfig = repository.EncodedConfig("path: /var/log/%s/access.log\nstate_dir: /tmp/state\n")
+
+	var cfg repository.PluginConfig
+	plainConfig.Decode(&cfg)
+	f, err := NewFileFetcher(cfg)
+	assert.NoError(t, err)
+
+	_, err = NewFileFetcher(repository.PluginConfig{})
+	assert.Error(t, err)
+
+	castedF := f.(*fileFetcher)
+	assert.Equal(t, "/var/log/%s/access.log", castedF.Path)
+	assert.Equal(t, "/tmp/state", castedF.StateDir)
+	assert.Equal(t, ".1", castedF.RotatedSuffix)
+}
+
+func TestFileFetcherFetch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filefetcher")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	logPath := filepath.Join(dir, "access.log")
+	appendLog := func(path, data string) {
+		fd, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		assert.NoError(t, err)
+		fd.WriteString(data)
+		fd.Close()
+	}
+	appendLog(logPath, "old line\n")
+
+	f, err := NewFileFetcher(repository.PluginConfig{
+		"path":      logPath,
+		"state_dir": filepath.Join(dir, "state"),
+	})
+	assert.NoError(t, err)
+	task := &FetcherTask{ID: "ID", Config: "cfg", Period: 60, Target: "localhost"}
+
+	_, err = f.Fetch(context.Background(), task)
+	assertErrorIfContextWithoutDeadline(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	cases := []struct {
+		prepare  func()
+		expected string
+	}{
+		// without saved state reading starts from the end
+		{func() {}, ""},
+		{func() { appendLog(logPath, "line1\nline2\npart") }, "line1\nline2\n"},
+		{func() { appendLog(logPath, "ial\n") }, "partial\n"},
+		// rotation
+		{func() {
+			appendLog(logPath, "before rotate\n")
+			assert.NoError(t, os.Rename(logPath, logPath+".1"))
+			appendLog(logPath, "after rotate\n")
+		}, "before rotate\nafter rotate\n"},
+		// truncation
+		{func() { assert.NoError(t, os.Truncate(logPath, 0)); appendLog(logPath, "new\n") }, "new\n"},
+		{func() {}, ""},
+	}
+	for _, c := range cases {
+		c.prepare()
+		body, err := f.Fetch(ctx, task)
+		assert.NoError(t, err)
+		assert.Equal(t, c.expected, string(body))
+	}
+
+	// other configs have their own offsets
+	otherTask := &FetcherTask{ID: "ID", Config: "other", Period: 60, Target: "localhost"}
+	body, err := f.Fetch(ctx, otherTask)
+	assert.NoError(t, err)
+	assert.Empty(t, body)
+
+	// max_size
+	f, err = NewFileFetcher(repository.PluginConfig{
+		"path":      logPath,
+		"state_dir": filepath.Join(dir, "state"),
+		"max_size":  8,
+	})
+	assert.NoError(t, err)
+	cases = []struct {
+		prepare  func()
+		expected string
+	}{
+		{func() {}, ""},
+		// line longer than max_size is read whole
+		{func() { appendLog(logPath, "very long line\nab\n") }, "very long line\n"},
+		{func() {}, "ab\n"},
+		{func() { appendLog(logPath, "incomplete long") }, ""},
+		{func() { appendLog(logPath, " line\n") }, "incomplete long line\n"},
+		// the rest of the rotated file is not dropped
+		{func() {
+			appendLog(logPath, "r1\nr2\nr3\nr4\nr5\n")
+			assert.NoError(t, os.Rename(logPath, logPath+".1"))
+			appendLog(logPath, "n1\n")
+		}, "r1\nr2\n"},
+		{func() {}, "r3\nr4\n"},
+		{func() {}, "r5\nn1\n"},
+		{func() {}, ""},
+	}
+	for _, c := range cases {
+		c.prepare()
+		body, err := f.Fetch(ctx, task)
+		assert.NoError(t, err)
+		assert.Equal(t, c.expected, string(body))
+	}
+}
+
+func TestHTTPFetcherClientConfig(t *testing.T) {
+	var plainConfig = repository.EncodedConfig(`
+port: 443
+scheme: HTTPS
+body: '{"q": 1}'
+headers: {X-Custom: value, Host: stats.local}
+token: secret
+`)
+	var cfg repository.PluginConfig
+	plainConfig.Decode(&cfg)
+	f, err := NewHTTPFetcher(cfg)
+	assert.NoError(t, err)
+
+	castedF := f.(*httpFetcher)
+	assert.Equal(t, "https", castedF.Scheme)
+	assert.Equal(t, "POST", castedF.Method)
+	assert.Equal(t, map[string]string{"X-Custom": "value", "Host": "stats.local"}, castedF.Headers)
+
+	bad := []repository.PluginConfig{
+		{"port": 80, "scheme": "ftp"},
+		{"port": 80, "token": "a", "token_file": "b"},
+		{"port": 80, "tls_cert_file": "cert.pem"},
+		{"port": 80, "tls_ca_file": "/not/existing/ca.pem"},
+	}
+	for _, c := range bad {
+		_, err := NewHTTPFetcher(c)
+		assert.Error(t, err, fmt.Sprintf("%v", c))
+	}
+	_, err = NewTimetailFetcher(repository.PluginConfig{"timetail_port": 80, "scheme": "ftp"})
+	assert.Error(t, err)
+}
+
+func TestHTTPFetcherTLSAndAuth(t *testing.T) {
+	type request struct {
+		method, host, auth, custom, body string
+		user, password                   string
+	}
+	reqCh := make(chan request, 1)
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		user, password, _ := r.BasicAuth()
+		reqCh <- request{
+			method: r.Method, host: r.Host, auth: r.Header.Get("Authorization"),
+			custom: r.Header.Get("X-Custom"), body: string(body),
+			user: user, password: password,
+		}
+		fmt.Fprint(w, "secure")
+	}))
+	defer ts.Close()
+	target, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+	dir, err := ioutil.TempDir("", "httpfetcher")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	assert.NoError(t, ioutil.WriteFile(caFile, certPEM, 0644))
+	tokenFile := filepath.Join(dir, "token")
+	assert.NoError(t, ioutil.WriteFile(tokenFile, []byte("file-token\n"), 0644))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	task := &FetcherTask{ID: "ID", Target: target}
+
+	// unknown CA
+	f, err := NewHTTPFetcher(repository.PluginConfig{"port": port, "scheme": "https"})
+	assert.NoError(t, err)
+	_, err = f.Fetch(ctx, task)
+	assert.Error(t, err)
+
+	cases := []struct {
+		config   repository.PluginConfig
+		expected request
+	}{
+		{
+			repository.PluginConfig{
+				"tls_ca_file": caFile, "tls_server_name": "example.com",
+				"token_file": tokenFile, "headers": map[string]interface{}{"X-Custom": "1"},
+			},
+			request{method: "GET", host: target + ":" + port, auth: "Bearer file-token", custom: "1"},
+		},
+		{
+			repository.PluginConfig{
+				"tls_insecure_skip_verify": true, "method": "put", "body": "data",
+				"basic_auth_user": "user", "basic_auth_password": "pass",
+				"headers": map[string]interface{}{"Host": "stats.local"},
+			},
+			request{method: "PUT", host: "stats.local", body: "data", user: "user", password: "pass"},
+		},
+	}
+	for _, c := range cases {
+		c.config["port"] = port
+		c.config["scheme"] = "https"
+		f, err := NewHTTPFetcher(c.config)
+		assert.NoError(t, err)
+		body, err := f.Fetch(ctx, task)
+		assert.NoError(t, err)
+		assert.Equal(t, "secure", string(body))
+		r := <-reqCh
+		if c.expected.auth == "" && c.expected.user != "" {
+			r.auth = ""
+		}
+		assert.Equal(t, c.expected, r)
+	}
+
+	tt, err := NewTimetailFetcher(repository.PluginConfig{
+		"timetail_port": port, "timetail_url": "/timetail?log=", "scheme": "https",
+		"tls_ca_file": caFile, "tls_server_name": "example.com", "token": "static",
+	})
+	assert.NoError(t, err)
+	body, err := tt.Fetch(ctx, task)
+	assert.NoError(t, err)
+	assert.Equal(t, "secure", string(body))
+	assert.Equal(t, "Bearer static", (<-reqCh).auth)
+}
+
+func TestHTTPFetcherStatusAndBodyPolicy(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		switch r.URL.Path {
+		case "/500":
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "error page")
+		case "/flaky":
+			if n%2 == 1 {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			fmt.Fprint(w, "recovered")
+		case "/404":
+			w.WriteHeader(http.StatusNotFound)
+		case "/large":
+			fmt.Fprint(w, strings.Repeat("x", 100))
+		case "/chunked":
+			for i := 0; i < 10; i++ {
+				fmt.Fprint(w, strings.Repeat("x", 10))
+				w.(http.Flusher).Flush()
+			}
+		case "/truncated":
+			conn, buf, _ := w.(http.Hijacker).Hijack()
+			buf.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\nshort")
+			buf.Flush()
+			conn.Close()
+		default:
+			fmt.Fprint(w, "ok")
+		}
+	}))
+	defer ts.Close()
+	target, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+	cases := []struct {
+		uri      string
+		config   repository.PluginConfig
+		reason   string
+		expected string
+	}{
+		{"/500", repository.PluginConfig{}, ReasonStatus, ""},
+		{"/500", repository.PluginConfig{"accepted_codes": []int{200, 500}}, "", "error page"},
+		{"/404", repository.PluginConfig{"retry": map[string]interface{}{"attempts": 3}}, ReasonStatus, ""},
+		{"/flaky", repository.PluginConfig{}, ReasonStatus, ""},
+		{"/flaky", repository.PluginConfig{"retry": map[string]interface{}{"attempts": 2}}, "", "recovered"},
+		{"/large", repository.PluginConfig{"max_body_size": 100}, "", strings.Repeat("x", 100)},
+		{"/large", repository.PluginConfig{"max_body_size": 99}, ReasonBodyTooLarge, ""},
+		{"/chunked", repository.PluginConfig{"max_body_size": 99}, ReasonBodyTooLarge, ""},
+		{"/truncated", repository.PluginConfig{}, ReasonBodyTruncated, ""},
+	}
+	for _, c := range cases {
+		atomic.StoreInt32(&calls, 0)
+		c.config["port"] = port
+		c.config["uri"] = c.uri
+		f, err := NewFetcher("http", c.config)
+		assert.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		body, err := f.Fetch(ctx, &FetcherTask{ID: "ID", Target: target})
+		cancel()
+		if c.reason != "" {
+			assert.Error(t, err, c.uri)
+			assert.Equal(t, c.reason, ErrorReason(err), c.uri)
+		} else {
+			assert.NoError(t, err, c.uri)
+			assert.Equal(t, c.expected, string(body), c.uri)
+		}
+	}
+	assert.Equal(t, ReasonTimeout, ErrorReason(context.DeadlineExceeded))
+	assert.Equal(t, ReasonOther, ErrorReason(fmt.Errorf("any")))
+	assert.Equal(t, "", ErrorReason(nil))
+}
+
+func compressPayload(t *testing.T, encoding string, data []byte) []byte {
+	var buf bytes.Buffer
+	switch encoding {
+	case "gzip":
+		w := gzip.NewWriter(&buf)
+		w.Write(data)
+		w.Close()
+	case "zstd":
+		w, err := zstd.NewWriter(&buf)
+		assert.NoError(t, err)
+		w.Write(data)
+		w.Close()
+	case "snappy":
+		w := snappy.NewBufferedWriter(&buf)
+		w.Write(data)
+		w.Close()
+	case "snappy-block":
+		return snappy.Encode(nil, data)
+	default:
+		return data
+	}
+	return buf.Bytes()
+}
+
+func TestDecompress(t *testing.T) {
+	payload := []byte(strings.Repeat("compressible payload\n", 100))
+	for _, enc := range []string{"gzip", "zstd", "snappy"} {
+		compressed := compressPayload(t, enc, payload)
+		assert.Equal(t, enc, detectEncoding(compressed))
+		decoded, err := decompress(enc, compressed, 0)
+		assert.NoError(t, err, enc)
+		assert.Equal(t, payload, decoded, enc)
+
+		_, err = decompress(enc, compressed, int64(len(payload)-1))
+		assert.Equal(t, errDecodedTooLarge, err, enc)
+	}
+	assert.Equal(t, "", detectEncoding(payload))
+
+	decoded, err := decompress("snappy", compressPayload(t, "snappy-block", payload), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, payload, decoded)
+
+	// encodings applied in order gzip then zstd
+	twice := compressPayload(t, "zstd", compressPayload(t, "gzip", payload))
+	decoded, err = decompress("gzip, zstd", twice, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, payload, decoded)
+
+	_, err = decompress("br", payload, 0)
+	assert.Error(t, err)
+	_, err = decompress("gzip", payload, 0)
+	assert.Error(t, err)
+}
+
+func TestFetchersDecompression(t *testing.T) {
+	payload := []byte(strings.Repeat("compressible payload\n", 100))
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		enc := r.URL.Query().Get("enc")
+		if enc == "accept" {
+			w.Write([]byte(r.Header.Get("Accept-Encoding")))
+			return
+		}
+		if enc == "broken" {
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(payload)
+			return
+		}
+		if enc != "" {
+			w.Header().Set("Content-Encoding", enc)
+		}
+		w.Write(compressPayload(t, enc, payload))
+	}))
+	defer ts.Close()
+	target, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	task := &FetcherTask{ID: "ID", Target: target}
+	for _, enc := range []string{"", "gzip", "zstd", "snappy"} {
+		f, err := NewHTTPFetcher(repository.PluginConfig{
+			"port": port, "uri": "/?enc=" + enc, "accept_encoding": "zstd, gzip, snappy",
+		})
+		assert.NoError(t, err)
+		body, err := f.Fetch(ctx, task)
+		assert.NoError(t, err, enc)
+		assert.Equal(t, payload, body, enc)
+
+		tt, err := NewTimetailFetcher(repository.PluginConfig{
+			"timetail_port": port, "timetail_url": "/?enc=" + enc,
+		})
+		assert.NoError(t, err)
+		body, err = tt.Fetch(ctx, task)
+		assert.NoError(t, err, enc)
+		assert.Equal(t, payload, body, enc)
+	}
+
+	f, err := NewHTTPFetcher(repository.PluginConfig{"port": port, "uri": "/?enc=broken"})
+	assert.NoError(t, err)
+	_, err = f.Fetch(ctx, task)
+	assert.Equal(t, ReasonDecompress, ErrorReason(err))
+
+	acceptCases := []struct {
+		config   repository.PluginConfig
+		expected string
+	}{
+		{repository.PluginConfig{}, "gzip"},
+		{repository.PluginConfig{"accept_encoding": "none"}, "identity"},
+		{repository.PluginConfig{"accept_encoding": "identity"}, "identity"},
+		{repository.PluginConfig{"accept_encoding": "zstd",
+			"headers": map[string]string{"accept-encoding": "snappy"}}, "snappy"},
+	}
+	for _, c := range acceptCases {
+		c.config["port"] = port
+		c.config["uri"] = "/?enc=accept"
+		f, err := NewHTTPFetcher(c.config)
+		assert.NoError(t, err)
+		body, err := f.Fetch(ctx, task)
+		assert.NoError(t, err)
+		assert.Equal(t, c.expected, string(body))
+	}
+
+	f, err = NewHTTPFetcher(repository.PluginConfig{"port": port, "uri": "/?enc=zstd", "max_body_size": 1000})
+	assert.NoError(t, err)
+	_, err = f.Fetch(ctx, task)
+	assert.Equal(t, ReasonBodyTooLarge, ErrorReason(err))
+
+	l, err := net.Listen("tcp4", "")
+	assert.NoError(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write(compressPayload(t, "zstd", payload))
+			conn.Close()
+		}
+	}()
+	target, port, _ = net.SplitHostPort(l.Addr().String())
+	rs, err := NewTCPSocketFetcher(repository.PluginConfig{"port": port})
+	assert.NoError(t, err)
+	body, err := rs.Fetch(ctx, &FetcherTask{ID: "ID", Target: target})
+	assert.NoError(t, err)
+	assert.Equal(t, payload, body)
+}
+
+func TestUnixSocketFetcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unixsocket")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	rawPath := filepath.Join(dir, "raw.sock")
+	l, err := net.Listen("unix", rawPath)
+	assert.NoError(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			buf := make([]byte, 4)
+			conn.Read(buf)
+			conn.Write(append([]byte("hello "), buf...))
+			conn.Close()
+		}
+	}()
+
+	httpPath := filepath.Join(dir, "http.sock")
+	hl, err := net.Listen("unix", httpPath)
+	assert.NoError(t, err)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/stats" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, "%s %s", r.Method, r.Host)
+	})}
+	go srv.Serve(hl)
+	defer srv.Close()
+	assert.NoError(t, os.Symlink(httpPath, filepath.Join(dir, "localhost.sock")))
+
+	_, err = NewUnixSocketFetcher(repository.PluginConfig{})
+	assert.Error(t, err)
+	_, err = NewUnixSocketFetcher(repository.PluginConfig{"path": httpPath, "scheme": "https"})
+	assert.Error(t, err)
+
+	cases := []struct {
+		config   repository.PluginConfig
+		expected string
+		reason   string
+	}{
+		{repository.PluginConfig{"path": filepath.Join(dir, "%s.sock"), "request": "PING"}, "hello PING", ""},
+		{repository.PluginConfig{"path": httpPath, "uri": "/stats"}, "GET localhost", ""},
+		{repository.PluginConfig{"path": httpPath, "uri": "/stats", "method": "POST"}, "POST localhost", ""},
+		{repository.PluginConfig{"path": filepath.Join(dir, "%s.sock"), "uri": "/stats"}, "GET localhost", ""},
+		{repository.PluginConfig{"path": httpPath, "uri": "/"}, "", ReasonStatus},
+		{repository.PluginConfig{"path": filepath.Join(dir, "missing.sock")}, "", ReasonNetwork},
+	}
+	for _, c := range cases {
+		f, err := NewUnixSocketFetcher(c.config)
+		assert.NoError(t, err)
+
+		task := &FetcherTask{ID: "ID", Target: "raw"}
+		if c.config["uri"] != nil {
+			task.Target = "localhost"
+		}
+		_, err = f.Fetch(context.Background(), task)
+		assertErrorIfContextWithoutDeadline(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		body, err := f.Fetch(ctx, task)
+		cancel()
+		if c.reason != "" {
+			assert.Equal(t, c.reason, ErrorReason(err), fmt.Sprintf("%v", c.config))
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, c.expected, string(body))
+	}
+	unixHTTPClients.Lock()
+	_, ok := unixHTTPClients.m[filepath.Join(dir, "%s.sock")]
+	_, formatted := unixHTTPClients.m[filepath.Join(dir, "localhost.sock")]
+	unixHTTPClients.Unlock()
+	assert.True(t, ok, "templated path must share one client")
+	assert.False(t, formatted, "client must not be cached per formatted path")
+}
+
+func TestExecFetcher(t *testing.T) {
+	_, err := NewExecFetcher(repository.PluginConfig{})
+	assert.Error(t, err)
+
+	var cfg repository.PluginConfig
+	plainConfig := repository.EncodedConfig(`command: [sh, -c, 'echo "$0 $1 $COMBAINE_TARGET $COMBAINE_PERIOD $VAR"']
+env: {VAR: value}`)
+	assert.NoError(t, plainConfig.Decode(&cfg))
+	f, err := NewExecFetcher(cfg)
+	assert.NoError(t, err)
+
+	task := &FetcherTask{ID: "ID", Target: "host1", Period: 60}
+	_, err = f.Fetch(context.Background(), task)
+	assertErrorIfContextWithoutDeadline(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	body, err := f.Fetch(ctx, task)
+	assert.NoError(t, err)
+	assert.Equal(t, "host1 60 host1 60 value\n", string(body))
+
+	f, err = NewExecFetcher(repository.PluginConfig{"command": []string{"sh", "-c", "echo oops >&2; exit 1"}})
+	assert.NoError(t, err)
+	_, err = f.Fetch(ctx, task)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "oops")
+
+	f, err = NewExecFetcher(repository.PluginConfig{"command": "sleep"})
+	assert.NoError(t, err)
+	shortCtx, shortCancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer shortCancel()
+	_, err = f.Fetch(shortCtx, &FetcherTask{ID: "ID", Target: "10", Period: 10})
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// background child holds stdout after the command exits
+	f, err = NewExecFetcher(repository.PluginConfig{"command": []string{"sh", "-c", "sleep 10 & echo started"}})
+	assert.NoError(t, err)
+	shortCtx, shortCancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer shortCancel()
+	start := time.Now()
+	_, err = f.Fetch(shortCtx, task)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, time.Since(start) < 2*time.Second, "fetch waits for children %v", time.Since(start))
+
+	_, err = NewExecFetcher(repository.PluginConfig{"command": "yes", "max_size": -1})
+	assert.Error(t, err)
+	f, err = NewExecFetcher(repository.PluginConfig{"command": "yes", "max_size": 1024})
+	assert.NoError(t, err)
+	_, err = f.Fetch(ctx, task)
+	assert.Equal(t, ReasonBodyTooLarge, ErrorReason(err))
+	f, err = NewExecFetcher(repository.PluginConfig{"command": []string{"sh", "-c", "printf 12345678"}, "max_size": 8})
+	assert.NoError(t, err)
+	body, err = f.Fetch(ctx, task)
+	assert.NoError(t, err)
+	assert.Equal(t, "12345678", string(body))
+}
+
+func TestTemplatedFetcherURL(t *testing.T) {
+	var requested []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.RequestURI())
+		fmt.Fprint(w, "ok")
+	}))
+	defer ts.Close()
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+
+	task := &FetcherTask{ID: "sid", Target: "front1.example.net", Datacenter: "dc1",
+		Period: 60, Start: 1000, End: 1060}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	cases := []struct {
+		name   string
+		config repository.PluginConfig
+		uri    string
+	}{
+		{"http", repository.PluginConfig{
+			"type": "http", "port": portNum, "connect_host": "127.0.0.1",
+			"uri": "/stats/{{.Datacenter}}/{{.Target}}?from={{.Start}}&to={{.End}}&s={{.ID}}"},
+			"/stats/dc1/front1.example.net?from=1000&to=1060&s=sid"},
+		{"timetail", repository.PluginConfig{
+			"type": "timetail", "timetail_port": portNum, "connect_host": "{{if .Target}}127.0.0.1{{end}}",
+			"logname":      "nginx/access.log",
+			"timetail_url": "/timetail?log={{.Options.logname}}&time={{.Period}}&port={{.Port}}"},
+			"/timetail?log=nginx/access.log&time=60&port=" + port},
+		{"timetail legacy", repository.PluginConfig{
+			"type": "timetail", "timetail_port": portNum, "connect_host": "127.0.0.1",
+			"logname": "nginx/access.log", "timetail_url": "/timetail?log="},
+			"/timetail?log=nginx/access.log&time=60"},
+	}
+	for _, c := range cases {
+		fType, _ := c.config.Type()
+		f, err := NewFetcher(fType, c.config)
+		assert.NoError(t, err, c.name)
+		requested = nil
+		_, err = f.Fetch(ctx, task)
+		assert.NoError(t, err, c.name)
+		assert.Equal(t, []string{c.uri}, requested, c.name)
+	}
+
+	_, err := NewHTTPFetcher(repository.PluginConfig{"port": 80, "uri": "/{{.Target"})
+	assert.Error(t, err)
+	f, err := NewHTTPFetcher(repository.PluginConfig{"port": portNum, "uri": "/{{.Unknown}}"})
+	assert.NoError(t, err)
+	_, err = f.Fetch(ctx, task)
+	assert.Error(t, err)
+}
+
+func TestTimetailFetcherIncremental(t *testing.T) {
+	var queries []url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.Query())
+		if r.URL.Query().Get("log_ts") == "102" {
+			return
+		}
+		fmt.Fprint(w, "tskv\tlog_ts=101\tstatus=200\ntskv\tlog_ts=102\tstatus=500\nplain line\n")
+	}))
+	defer ts.Close()
+	target, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+
+	f, err := NewTimetailFetcher(repository.PluginConfig{
+		"timetail_port": portNum, "timetail_url": "/timetail?pattern=nginx&log_ts=", "incremental": true})
+	assert.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	task := &FetcherTask{ID: "ID", Target: target, Period: 60}
+	_, err = f.Fetch(ctx, task)
+	assert.NoError(t, err)
+	assert.Equal(t, "102", task.NextCursor)
+	assert.Equal(t, "60", queries[0].Get("time"))
+	assert.Equal(t, "", queries[0].Get("log_ts"))
+
+	task = &FetcherTask{ID: "ID", Target: target, Period: 60, Cursor: task.NextCursor}
+	body, err := f.Fetch(ctx, task)
+	assert.NoError(t, err)
+	assert.Empty(t, body)
+	assert.Equal(t, "102", task.NextCursor)
+	assert.Equal(t, "102", queries[1].Get("log_ts"))
+	assert.Equal(t, "nginx", queries[1].Get("pattern"))
+	_, hasTime := queries[1]["time"]
+	assert.False(t, hasTime)
+
+	// too old cursor is moved forward to cursor_periods before the frame
+	task = &FetcherTask{ID: "ID", Target: target, Period: 60, Start: 10000, Cursor: "102"}
+	_, err = f.Fetch(ctx, task)
+	assert.NoError(t, err)
+	assert.Equal(t, "9700", queries[2].Get("log_ts"))
+
+	_, err = NewTimetailFetcher(repository.PluginConfig{"timetail_port": portNum, "cursor_periods": -1})
+	assert.Error(t, err)
+	assert.Equal(t, "9700", clampCursor("100", 9700))
+	assert.Equal(t, "9800", clampCursor("9800", 9700))
+	assert.Equal(t, "2020-01-01T00:00:00", clampCursor("2020-01-01T00:00:00", 9700))
+
+	assert.Equal(t, "", lastTskvField([]byte("a=1\tb=2\n"), "c"))
+	assert.Equal(t, "2", lastTskvField([]byte("a=1\tb=2\na=3\n"), "b"))
+}
@@ -0,0 +1,101 @@
+package fetchers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	gzipMagic   = []byte{0x1f, 0x8b}
+	zstdMagic   = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	snappyMagic = []byte("\xff\x06\x00\x00sNaPpY")
+
+	errDecodedTooLarge = errors.New("decoded payload is too large")
+)
+
+// detectEncoding return compression of the payload by magic bytes
+func detectEncoding(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, gzipMagic):
+		return "gzip"
+	case bytes.HasPrefix(data, zstdMagic):
+		return "zstd"
+	case bytes.HasPrefix(data, snappyMagic):
+		return "snappy"
+	}
+	return ""
+}
+
+// decompress decode payload with encodings listed as in Content-Encoding header,
+// limit is max size of the decoded payload, 0 is unlimited
+func decompress(encodings string, data []byte, limit int64) ([]byte, error) {
+	if encodings == "" {
+		return data, nil
+	}
+	list := strings.Split(encodings, ",")
+	// encodings are listed in the order in which they were applied
+	for i := len(list) - 1; i >= 0; i-- {
+		var err error
+		if data, err = decode(strings.TrimSpace(list[i]), data, limit); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+func decode(encoding string, data []byte, limit int64) ([]byte, error) {
+	var r io.Reader
+	switch strings.ToLower(encoding) {
+	case "", "identity":
+		return data, nil
+	case "gzip", "x-gzip":
+		gr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		r = gr
+	case "zstd":
+		zr, err := zstd.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	case "snappy", "x-snappy-framed":
+		if !bytes.HasPrefix(data, snappyMagic) {
+			// not framed, so it is the snappy block
+			size, err := snappy.DecodedLen(data)
+			if err != nil {
+				return nil, err
+			}
+			if limit > 0 && int64(size) > limit {
+				return nil, errDecodedTooLarge
+			}
+			return snappy.Decode(nil, data)
+		}
+		r = snappy.NewReader(bytes.NewReader(data))
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q", encoding)
+	}
+
+	if limit > 0 {
+		r = io.LimitReader(r, limit+1)
+	}
+	decoded, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && int64(len(decoded)) > limit {
+		return nil, errDecodedTooLarge
+	}
+	return decoded, nil
+}
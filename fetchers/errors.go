@@ -0,0 +1,90 @@
+package fetchers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+// Reasons of the fetch failures
+const (
+	// ReasonStatus target answered with not accepted status code
+	ReasonStatus = "status"
+	// ReasonBodyTooLarge body exceeds configured max_body_size
+	ReasonBodyTooLarge = "body_too_large"
+	// ReasonBodyTruncated body is shorter than announced by target
+	ReasonBodyTruncated = "body_truncated"
+	// ReasonDecompress payload can't be decompressed
+	ReasonDecompress = "decompress"
+	// ReasonNetwork connection to the target failed
+	ReasonNetwork = "network"
+	// ReasonTimeout fetching was interrupted by deadline
+	ReasonTimeout = "timeout"
+	// ReasonOther any other error
+	ReasonOther = "other"
+)
+
+// FetchError returned by fetchers when target answered with unusable data
+type FetchError struct {
+	Reason     string
+	URL        string
+	StatusCode int
+	Limit      int64
+	Err        error
+}
+
+func (e *FetchError) Error() string {
+	switch e.Reason {
+	case ReasonStatus:
+		return fmt.Sprintf("%s answered with status %d", e.URL, e.StatusCode)
+	case ReasonBodyTooLarge:
+		return fmt.Sprintf("%s body exceeds %d bytes", e.URL, e.Limit)
+	case ReasonBodyTruncated:
+		return fmt.Sprintf("%s body truncated: %v", e.URL, e.Err)
+	case ReasonDecompress:
+		return fmt.Sprintf("%s failed to decompress: %v", e.URL, e.Err)
+	}
+	return fmt.Sprintf("%s: %v", e.URL, e.Err)
+}
+
+// Temporary report whether fetching may succeed on retry
+func (e *FetchError) Temporary() bool {
+	switch e.Reason {
+	case ReasonStatus:
+		return e.StatusCode >= 500
+	case ReasonBodyTruncated:
+		return true
+	}
+	return false
+}
+
+// ErrorReason return reason of the fetch failure
+func ErrorReason(err error) string {
+	if err == nil {
+		return ""
+	}
+	cause := errors.Cause(err)
+	if ue, ok := cause.(*url.Error); ok {
+		cause = ue.Err
+	}
+	switch e := cause.(type) {
+	case *FetchError:
+		return e.Reason
+	case net.Error:
+		if e.Timeout() {
+			return ReasonTimeout
+		}
+		return ReasonNetwork
+	}
+	switch cause {
+	case context.DeadlineExceeded, context.Canceled:
+		return ReasonTimeout
+	case io.ErrUnexpectedEOF:
+		return ReasonNetwork
+	}
+	return ReasonOther
+}
@@ -0,0 +1,129 @@
+package fetchers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/combaine/combaine/repository"
+)
+
+const (
+	execStderrLimit = 1024
+	// execDefaultMaxSize limits stdout if max_size is not configured
+	execDefaultMaxSize = 64 << 20
+)
+
+func init() {
+	Register("exec", NewExecFetcher)
+}
+
+// execFetcher run command and capture its stdout,
+// the target host and the period are appended to the command arguments
+// and exported as COMBAINE_TARGET and COMBAINE_PERIOD
+type execFetcher struct {
+	Command []string          `mapstructure:"command"`
+	Env     map[string]string `mapstructure:"env"`
+	// MaxSize limits size of the command stdout
+	MaxSize int64 `mapstructure:"max_size"`
+}
+
+// execOutput buffer stdout of the command up to the limit,
+// overflow is discarded and reported by the exceeded callback
+type execOutput struct {
+	buf      bytes.Buffer
+	limit    int64
+	overflow bool
+	exceeded func()
+}
+
+func (o *execOutput) Write(p []byte) (int, error) {
+	if o.overflow {
+		return len(p), nil
+	}
+	if int64(o.buf.Len()+len(p)) > o.limit {
+		o.overflow = true
+		o.exceeded()
+		return len(p), nil
+	}
+	return o.buf.Write(p)
+}
+
+// NewExecFetcher return exec data fetcher
+func NewExecFetcher(cfg repository.PluginConfig) (Fetcher, error) {
+	var f execFetcher
+	if err := decodeConfig(cfg, &f); err != nil {
+		return nil, err
+	}
+	if len(f.Command) == 0 || f.Command[0] == "" {
+		return nil, errors.New("exec: Missing option command")
+	}
+	if f.MaxSize < 0 {
+		return nil, errors.New("exec: max_size must not be negative")
+	}
+	if f.MaxSize == 0 {
+		f.MaxSize = execDefaultMaxSize
+	}
+	return &f, nil
+}
+
+// Fetch run the command until it exits or the context deadline
+func (e *execFetcher) Fetch(ctx context.Context, task *FetcherTask) ([]byte, error) {
+	log := logrus.WithField("session", task.ID)
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return nil, errors.New("exec: Context without deadline")
+	}
+
+	period := strconv.FormatInt(task.Period, 10)
+	args := append(append([]string{}, e.Command[1:]...), task.Target, period)
+	cmd := exec.Command(e.Command[0], args...)
+	cmd.Env = append(os.Environ(), "COMBAINE_TARGET="+task.Target, "COMBAINE_PERIOD="+period)
+	for k, v := range e.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	// the command runs in its own process group, so children left by the
+	// command are killed with it and don't keep stdout open after the deadline
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	kill := func() { syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) }
+	stdout := &execOutput{limit: e.MaxSize, exceeded: kill}
+	var stderr bytes.Buffer
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+
+	log.Infof("exec: Run %s %v, timeout %v", e.Command[0], args, deadline.Sub(time.Now()))
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("exec: %s failed: %s", e.Command[0], err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		kill()
+		<-done
+		return nil, ctx.Err()
+	}
+	if stdout.overflow {
+		return nil, &FetchError{Reason: ReasonBodyTooLarge, URL: e.Command[0], Limit: e.MaxSize}
+	}
+	if err != nil {
+		msg := stderr.Bytes()
+		if len(msg) > execStderrLimit {
+			msg = msg[:execStderrLimit]
+		}
+		return nil, fmt.Errorf("exec: %s failed: %s: %q", e.Command[0], err, msg)
+	}
+	return stdout.buf.Bytes(), nil
+}
@@ -10,9 +10,24 @@ import (
 
 // FetcherTask task for hosts fetchers
 type FetcherTask struct {
-	ID     string
-	Period int64
-	Target string
+	ID         string
+	Config     string
+	Period     int64
+	Target     string
+	Datacenter string
+	// Start and End of the parsing time frame
+	Start int64
+	End   int64
+	// Cursor is position after the data fetched in the previous iteration,
+	// incremental fetchers set NextCursor after the fetched data
+	Cursor     string
+	NextCursor string
+	// SourceCursors are cursors of multi fetcher sources keyed by name,
+	// multi fetcher sets NextSourceCursors of its incremental sources
+	SourceCursors     map[string]string
+	NextSourceCursors map[string]string
+	// Labels of the target discovered along with it
+	Labels map[string]string
 }
 
 var fLock sync.Mutex
@@ -25,12 +40,34 @@ func Register(name string, f func(repository.PluginConfig) (Fetcher, error)) {
 	fLock.Unlock()
 }
 
+// Registered check that fetcher initializer is registered
+func Registered(name string) bool {
+	fLock.Lock()
+	_, ok := fetchers[name]
+	fLock.Unlock()
+	return ok
+}
+
 // Fetcher interface
 type Fetcher interface {
 	Fetch(ctx context.Context, task *FetcherTask) ([]byte, error)
 }
 
-// NewFetcher get and initialize new fetcher
+// StatefulFetcher is implemented by fetchers keeping state
+// per parsing config and target between iterations,
+// the data fetched by them can not be shared between parsing configs
+type StatefulFetcher interface {
+	Stateful() bool
+}
+
+// IsStateful check that the fetcher keeps state between iterations
+func IsStateful(f Fetcher) bool {
+	s, ok := f.(StatefulFetcher)
+	return ok && s.Stateful()
+}
+
+// NewFetcher get and initialize new fetcher,
+// the fetcher is wrapped with retry policy and recorder if they are configured
 func NewFetcher(name string, cfg repository.PluginConfig) (f Fetcher, err error) {
 	initializer, ok := fetchers[name]
 	if !ok {
@@ -39,5 +76,11 @@ func NewFetcher(name string, cfg repository.PluginConfig) (f Fetcher, err error)
 	}
 
 	f, err = initializer(cfg)
-	return
+	if err != nil {
+		return
+	}
+	if f, err = newRetryFetcherFromConfig(f, cfg); err != nil {
+		return
+	}
+	return newRecordFetcherFromConfig(f, name, cfg)
 }
@@ -38,3 +38,30 @@ func TestNewFetcher(t *testing.T) {
 	data, _ := f.Fetch(context.TODO(), nil)
 	assert.Equal(t, testFetcherName, string(data))
 }
+
+func TestIsStateful(t *testing.T) {
+	assert.False(t, IsStateful(&testFetcher{}))
+
+	configs := map[string]struct {
+		name     string
+		cfg      repository.PluginConfig
+		stateful bool
+	}{
+		"http":        {"http", repository.PluginConfig{"port": 80}, false},
+		"file":        {"file", repository.PluginConfig{"path": "/var/log/access.log"}, true},
+		"timetail":    {"timetail", repository.PluginConfig{"timetail_port": 1}, false},
+		"incremental": {"timetail", repository.PluginConfig{"timetail_port": 1, "incremental": true}, true},
+		"retry":       {"file", repository.PluginConfig{"path": "/var/log/access.log", "retry": map[string]interface{}{"attempts": 2}}, true},
+		"record":      {"http", repository.PluginConfig{"port": 80, "record": map[string]interface{}{"dir": "/tmp"}}, true},
+		"multi": {"multi", repository.PluginConfig{"fetchers": map[string]repository.PluginConfig{
+			"a": {"type": "http", "port": 1},
+			"b": {"type": "file", "path": "/var/log/access.log"},
+		}}, true},
+	}
+	for name, c := range configs {
+		f, err := NewFetcher(c.name, c.cfg)
+		if assert.NoError(t, err, name) {
+			assert.Equal(t, c.stateful, IsStateful(f), name)
+		}
+	}
+}
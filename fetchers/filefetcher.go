@@ -0,0 +1,284 @@
+package fetchers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/combaine/combaine/repository"
+)
+
+const (
+	defaultFileStateDir      = "/var/lib/combaine/file"
+	defaultFileRotatedSuffix = ".1"
+	defaultFileStalePeriods  = 3
+)
+
+func init() {
+	Register("file", NewFileFetcher)
+}
+
+// fileFetcher read lines appended to the local log file since the last iteration
+type fileFetcher struct {
+	// Path to the log file, `%s` is replaced with the target host
+	Path string `mapstructure:"path"`
+	// StateDir is directory where offsets are persisted
+	StateDir string `mapstructure:"state_dir"`
+	// RotatedSuffix is the suffix of the previous log file after rotation
+	RotatedSuffix string `mapstructure:"rotated_suffix"`
+	// StalePeriods is the number of periods after which saved offset is too old
+	// and reading starts from the end of the file
+	StalePeriods int64 `mapstructure:"stale_periods"`
+	// MaxSize limits amount of bytes read in one iteration, 0 is unlimited
+	MaxSize int64 `mapstructure:"max_size"`
+	// FromStart read whole file if there is no saved offset
+	FromStart bool `mapstructure:"from_start"`
+}
+
+// fileState is persisted position in the log file
+type fileState struct {
+	Inode  uint64 `json:"inode"`
+	Offset int64  `json:"offset"`
+	Saved  int64  `json:"saved"`
+}
+
+var fileStateLocks = struct {
+	sync.Mutex
+	m map[string]*sync.Mutex
+}{m: make(map[string]*sync.Mutex)}
+
+func lockFileState(key string) func() {
+	fileStateLocks.Lock()
+	l, ok := fileStateLocks.m[key]
+	if !ok {
+		l = new(sync.Mutex)
+		fileStateLocks.m[key] = l
+	}
+	fileStateLocks.Unlock()
+	l.Lock()
+	return l.Unlock
+}
+
+// NewFileFetcher return local log file fetcher
+func NewFileFetcher(cfg repository.PluginConfig) (Fetcher, error) {
+	var f fileFetcher
+	if err := decodeConfig(cfg, &f); err != nil {
+		return nil, err
+	}
+	if f.Path == "" {
+		return nil, errors.New("file: Missing option path")
+	}
+	if f.StateDir == "" {
+		f.StateDir = defaultFileStateDir
+	}
+	if f.RotatedSuffix == "" {
+		f.RotatedSuffix = defaultFileRotatedSuffix
+	}
+	if f.StalePeriods <= 0 {
+		f.StalePeriods = defaultFileStalePeriods
+	}
+	return &f, nil
+}
+
+// Stateful is true, offsets are kept per parsing config and target
+func (f *fileFetcher) Stateful() bool {
+	return true
+}
+
+// Fetch read lines appended since the previous call for the same config and file
+func (f *fileFetcher) Fetch(ctx context.Context, task *FetcherTask) ([]byte, error) {
+	log := logrus.WithField("session", task.ID)
+
+	if _, ok := ctx.Deadline(); !ok {
+		return nil, errors.New("file: Context without deadline")
+	}
+
+	path := f.Path
+	if strings.Contains(path, `%s`) {
+		path = fmt.Sprintf(path, task.Target)
+	}
+	statePath := f.statePath(task.Config, path)
+
+	unlock := lockFileState(statePath)
+	defer unlock()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	inode := fileInode(info)
+	now := time.Now()
+
+	state, err := readFileState(statePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("file: Failed to read state %s: %s", statePath, err)
+		}
+		state = nil
+	}
+
+	if state != nil && task.Period > 0 && now.Unix()-state.Saved > f.StalePeriods*task.Period {
+		log.Warnf("file: Saved offset for %s is stale (saved at %s), skip to the end",
+			path, time.Unix(state.Saved, 0))
+		state = nil
+	}
+	if state == nil {
+		state = &fileState{Inode: inode, Offset: info.Size()}
+		if f.FromStart {
+			state.Offset = 0
+		}
+	}
+
+	var body []byte
+	if state.Inode != inode {
+		// file rotated, read the rest of the previous file first
+		rotated := path + f.RotatedSuffix
+		if rInfo, err := os.Stat(rotated); err == nil && fileInode(rInfo) == state.Inode {
+			if f.MaxSize > 0 && rInfo.Size()-state.Offset > f.MaxSize {
+				// the rest of the rotated file is read in the next iterations
+				data, read, err := f.readFrom(rotated, state.Offset, f.MaxSize, false)
+				if err != nil {
+					return nil, err
+				}
+				state.Offset += read
+				state.Saved = now.Unix()
+				log.Infof("file: Read %d bytes from rotated %s", len(data), rotated)
+				if err := writeFileState(statePath, state); err != nil {
+					log.Errorf("file: Failed to save state %s: %s", statePath, err)
+				}
+				return data, nil
+			}
+			body, _, err = f.readFrom(rotated, state.Offset, 0, true)
+			if err != nil {
+				log.Warnf("file: Failed to read rotated file %s: %s", rotated, err)
+			}
+		} else {
+			log.Warnf("file: %s rotated, but previous file is not found", path)
+		}
+		state = &fileState{Inode: inode}
+	}
+	if info.Size() < state.Offset {
+		log.Infof("file: %s truncated, read from the start", path)
+		state.Offset = 0
+	}
+
+	limit := f.MaxSize
+	if limit > 0 {
+		limit -= int64(len(body))
+	}
+	if f.MaxSize == 0 || limit > 0 {
+		data, read, err := f.readFrom(path, state.Offset, limit, false)
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, data...)
+		state.Offset += read
+	}
+	state.Saved = now.Unix()
+
+	log.Infof("file: Read %d bytes from %s", len(body), path)
+	if err := writeFileState(statePath, state); err != nil {
+		log.Errorf("file: Failed to save state %s: %s", statePath, err)
+	}
+	return body, nil
+}
+
+// readFrom read lines from offset, and return data with amount of consumed bytes,
+// the last incomplete line is returned only if partial is true.
+// At least one complete line is read even if it is longer than limit
+func (f *fileFetcher) readFrom(path string, offset int64, limit int64, partial bool) ([]byte, int64, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer fd.Close()
+
+	if _, err := fd.Seek(offset, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+	var r io.Reader = fd
+	if limit > 0 {
+		r = io.LimitReader(fd, limit)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	if partial {
+		return data, int64(len(data)), nil
+	}
+	// incomplete line is left to the next iteration
+	end := bytes.LastIndexByte(data, '\n') + 1
+	if end == 0 && limit > 0 && int64(len(data)) == limit {
+		// the line is longer than limit, otherwise the offset never moves
+		rest, err := bufio.NewReader(fd).ReadBytes('\n')
+		switch err {
+		case nil:
+			data = append(data, rest...)
+			end = len(data)
+		case io.EOF:
+			// the line is not written completely yet
+		default:
+			return nil, 0, err
+		}
+	}
+	return data[:end], int64(end), nil
+}
+
+func (f *fileFetcher) statePath(config, path string) string {
+	name := fmt.Sprintf("%x", md5.Sum([]byte(config+";"+path)))
+	return filepath.Join(f.StateDir, name+".json")
+}
+
+func fileInode(info os.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return st.Ino
+	}
+	return 0
+}
+
+func readFileState(path string) (*fileState, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var state fileState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+func writeFileState(path string, state *fileState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// writeFileAtomic write data to temporary file and rename it to path
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
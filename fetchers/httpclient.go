@@ -0,0 +1,235 @@
+package fetchers
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/combaine/combaine/common/chttp"
+)
+
+// httpClientConfig is common request options for http based fetchers
+type httpClientConfig struct {
+	// Scheme is http or https
+	Scheme string `mapstructure:"scheme"`
+	// Method of the request, POST if Body is set and GET otherwise
+	Method string `mapstructure:"method"`
+	// Body of the request
+	Body string `mapstructure:"body"`
+	// Headers added to the request
+	Headers map[string]string `mapstructure:"headers"`
+	// Token is static bearer token
+	Token string `mapstructure:"token"`
+	// TokenFile is file with bearer token, it is read on every request
+	TokenFile string `mapstructure:"token_file"`
+	// BasicAuthUser and BasicAuthPassword enable basic auth
+	BasicAuthUser     string `mapstructure:"basic_auth_user"`
+	BasicAuthPassword string `mapstructure:"basic_auth_password"`
+	// TLS options
+	CAFile             string `mapstructure:"tls_ca_file"`
+	CertFile           string `mapstructure:"tls_cert_file"`
+	KeyFile            string `mapstructure:"tls_key_file"`
+	ServerName         string `mapstructure:"tls_server_name"`
+	InsecureSkipVerify bool   `mapstructure:"tls_insecure_skip_verify"`
+	// AcceptedCodes is list of status codes treated as success, any 2xx by default
+	AcceptedCodes []int `mapstructure:"accepted_codes"`
+	// MaxBodySize limits size of the response body, 0 is unlimited,
+	// it is applied to both compressed and decompressed body
+	MaxBodySize int64 `mapstructure:"max_body_size"`
+	// AcceptEncoding ask for compressed transfer, e.g. "zstd, gzip", default is gzip,
+	// "identity" or "none" disables compression, Accept-Encoding from Headers
+	// takes precedence, body is decompressed according to Content-Encoding
+	AcceptEncoding string `mapstructure:"accept_encoding"`
+
+	client *http.Client
+}
+
+// setup validate options and prepare http client
+func (c *httpClientConfig) setup() error {
+	c.Scheme = strings.ToLower(c.Scheme)
+	switch c.Scheme {
+	case "":
+		c.Scheme = "http"
+	case "http", "https":
+	default:
+		return errors.Errorf("unsupported scheme %q", c.Scheme)
+	}
+	c.Method = strings.ToUpper(c.Method)
+	if c.Method == "" {
+		c.Method = http.MethodGet
+		if c.Body != "" {
+			c.Method = http.MethodPost
+		}
+	}
+	switch strings.ToLower(strings.TrimSpace(c.AcceptEncoding)) {
+	case "":
+		c.AcceptEncoding = "gzip"
+	case "none", "identity":
+		c.AcceptEncoding = "identity"
+	}
+	if c.Token != "" && c.TokenFile != "" {
+		return errors.New("only one of token and token_file may be set")
+	}
+	if c.MaxBodySize < 0 {
+		return errors.New("max_body_size must not be negative")
+	}
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return errors.New("both tls_cert_file and tls_key_file are required")
+	}
+
+	if c.CAFile == "" && c.CertFile == "" && c.ServerName == "" && !c.InsecureSkipVerify {
+		c.client = http.DefaultClient
+		return nil
+	}
+	key := fmt.Sprintf("fetchers|%s|%s|%s|%s|%t",
+		c.CAFile, c.CertFile, c.KeyFile, c.ServerName, c.InsecureSkipVerify)
+	client, err := chttp.CachedClient(key, []string{c.CAFile, c.CertFile, c.KeyFile}, func() (*http.Client, error) {
+		tlsConfig, err := c.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		return &http.Client{Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			TLSClientConfig:     tlsConfig,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: 10 * time.Second,
+		}}, nil
+	})
+	if err != nil {
+		return err
+	}
+	c.client = client
+	return nil
+}
+
+func (c *httpClientConfig) tlsConfig() (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if c.CAFile != "" {
+		pem, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "read tls_ca_file")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificates found in %s", c.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "load client certificate")
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// fetch send the request, check the status code and read the body
+// with respect of configured limits, 5xx answers are retried
+// by the `retry` section of the fetcher config
+func (c *httpClientConfig) fetch(ctx context.Context, url string) ([]byte, error) {
+	resp, err := c.do(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if !c.accepted(resp.StatusCode) {
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+		return nil, &FetchError{Reason: ReasonStatus, URL: url, StatusCode: resp.StatusCode}
+	}
+
+	var r io.Reader = resp.Body
+	if c.MaxBodySize > 0 {
+		if resp.ContentLength > c.MaxBodySize {
+			return nil, &FetchError{Reason: ReasonBodyTooLarge, URL: url, Limit: c.MaxBodySize}
+		}
+		r = io.LimitReader(resp.Body, c.MaxBodySize+1)
+	}
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+		if err == io.ErrUnexpectedEOF {
+			return nil, &FetchError{Reason: ReasonBodyTruncated, URL: url, Err: err}
+		}
+		return nil, err
+	}
+	if c.MaxBodySize > 0 && int64(len(body)) > c.MaxBodySize {
+		return nil, &FetchError{Reason: ReasonBodyTooLarge, URL: url, Limit: c.MaxBodySize}
+	}
+	body, err = decompress(resp.Header.Get("Content-Encoding"), body, c.MaxBodySize)
+	if err == errDecodedTooLarge {
+		return nil, &FetchError{Reason: ReasonBodyTooLarge, URL: url, Limit: c.MaxBodySize}
+	}
+	if err != nil {
+		return nil, &FetchError{Reason: ReasonDecompress, URL: url, Err: err}
+	}
+	return body, nil
+}
+
+func (c *httpClientConfig) accepted(code int) bool {
+	if len(c.AcceptedCodes) == 0 {
+		return code >= 200 && code < 300
+	}
+	for _, accepted := range c.AcceptedCodes {
+		if code == accepted {
+			return true
+		}
+	}
+	return false
+}
+
+// do build the request with configured options and send it
+func (c *httpClientConfig) do(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequest(c.Method, url, strings.NewReader(c.Body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range c.Headers {
+		if http.CanonicalHeaderKey(k) == "Host" {
+			req.Host = v
+			continue
+		}
+		req.Header.Set(k, v)
+	}
+	// net/http does not decompress body itself if Accept-Encoding is set
+	if req.Header.Get("Accept-Encoding") == "" && c.AcceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", c.AcceptEncoding)
+	}
+	token := c.Token
+	if c.TokenFile != "" {
+		data, err := ioutil.ReadFile(c.TokenFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "read token_file")
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if c.BasicAuthUser != "" {
+		req.SetBasicAuth(c.BasicAuthUser, c.BasicAuthPassword)
+	}
+	client := c.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return chttp.DoWithClient(ctx, client, req)
+}
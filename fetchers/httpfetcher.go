@@ -4,12 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"text/template"
 	"time"
 
 	"github.com/sirupsen/logrus"
 
-	"github.com/combaine/combaine/common/chttp"
 	"github.com/combaine/combaine/repository"
 )
 
@@ -18,8 +17,15 @@ func init() {
 }
 
 type httpFetcher struct {
-	Port int    `mapstructure:"port"`
-	URI  string `mapstructure:"uri"`
+	Port int `mapstructure:"port"`
+	// URI and ConnectHost may be go templates, see templateData
+	URI         string `mapstructure:"uri"`
+	ConnectHost string `mapstructure:"connect_host"`
+
+	httpClientConfig `mapstructure:",squash"`
+	uriTmpl          *template.Template
+	hostTmpl         *template.Template
+	options          repository.PluginConfig
 }
 
 // NewHTTPFetcher return http data fetcher
@@ -34,6 +40,17 @@ func NewHTTPFetcher(cfg repository.PluginConfig) (Fetcher, error) {
 	if fetcher.Port == 0 {
 		return nil, errors.New("httpfetcher: Missing option port")
 	}
+	if err := fetcher.setup(); err != nil {
+		return nil, fmt.Errorf("httpfetcher: %s", err)
+	}
+	var err error
+	if fetcher.uriTmpl, err = parseTemplate("uri", fetcher.URI); err != nil {
+		return nil, fmt.Errorf("httpfetcher: uri: %s", err)
+	}
+	if fetcher.hostTmpl, err = parseTemplate("connect_host", fetcher.ConnectHost); err != nil {
+		return nil, fmt.Errorf("httpfetcher: connect_host: %s", err)
+	}
+	fetcher.options = cfg
 
 	return &fetcher, nil
 }
@@ -46,14 +63,22 @@ func (t *httpFetcher) Fetch(ctx context.Context, task *FetcherTask) ([]byte, err
 		return nil, errors.New("httpfetcher: Context without deadline")
 	}
 
-	url := fmt.Sprintf("http://%s:%d%s", task.Target, t.Port, t.URI)
-	log.Infof("httpfetcher: Requested URL: %s, timeout %v", url, deadline.Sub(time.Now()))
-
-	resp, err := chttp.Get(ctx, url)
+	data := &templateData{FetcherTask: task, Port: t.Port, Options: t.options}
+	host := t.ConnectHost
+	if host == "" {
+		host = task.Target
+	}
+	host, err := renderTemplate(t.hostTmpl, host, data)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("httpfetcher: connect_host: %s", err)
+	}
+	uri, err := renderTemplate(t.uriTmpl, t.URI, data)
+	if err != nil {
+		return nil, fmt.Errorf("httpfetcher: uri: %s", err)
 	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	return body, err
+
+	url := fmt.Sprintf("%s://%s:%d%s", t.Scheme, host, t.Port, uri)
+	log.Infof("httpfetcher: Requested URL: %s %s, timeout %v", t.Method, url, deadline.Sub(time.Now()))
+
+	return t.fetch(ctx, url)
 }
@@ -0,0 +1,175 @@
+package fetchers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/combaine/combaine/repository"
+)
+
+func init() {
+	Register("multi", NewMultiFetcher)
+}
+
+// MultiFetcher is implemented by fetchers which return several named payloads,
+// aggregation data section can select one of them with the `source` option
+type MultiFetcher interface {
+	Fetcher
+	FetchMulti(ctx context.Context, task *FetcherTask) (map[string][]byte, error)
+}
+
+// multiFetcher run named sub fetchers concurrently
+type multiFetcher struct {
+	Fetchers map[string]repository.PluginConfig `mapstructure:"fetchers"`
+	// RequireAll fail fetching if any of sub fetchers failed
+	RequireAll bool `mapstructure:"require_all"`
+
+	fetchers map[string]Fetcher
+}
+
+// NewMultiFetcher return composite fetcher
+func NewMultiFetcher(cfg repository.PluginConfig) (Fetcher, error) {
+	var f multiFetcher
+	if err := decodeConfig(cfg, &f); err != nil {
+		return nil, err
+	}
+	if len(f.Fetchers) == 0 {
+		return nil, errors.New("multi: Missing option fetchers")
+	}
+	for _, key := range []string{retryKey, recordKey} {
+		if _, ok := cfg[key]; ok {
+			return nil, errors.Errorf("multi: %s should be configured for sub fetchers", key)
+		}
+	}
+	f.fetchers = make(map[string]Fetcher, len(f.Fetchers))
+	for name, subCfg := range f.Fetchers {
+		fType, err := subCfg.Type()
+		if err != nil {
+			return nil, fmt.Errorf("multi: %s: %s", name, err)
+		}
+		if fType == "multi" {
+			return nil, fmt.Errorf("multi: %s: nested multi fetcher", name)
+		}
+		if subCfg, err = withRecordName(subCfg, name); err != nil {
+			return nil, fmt.Errorf("multi: %s: %s", name, err)
+		}
+		sub, err := NewFetcher(fType, subCfg)
+		if err != nil {
+			return nil, fmt.Errorf("multi: %s: %s", name, err)
+		}
+		f.fetchers[name] = sub
+	}
+	return &f, nil
+}
+
+// Stateful check that any of sub fetchers keeps state
+func (m *multiFetcher) Stateful() bool {
+	for _, f := range m.fetchers {
+		if IsStateful(f) {
+			return true
+		}
+	}
+	return false
+}
+
+// FetchMulti return payloads of succeeded sub fetchers
+func (m *multiFetcher) FetchMulti(ctx context.Context, task *FetcherTask) (map[string][]byte, error) {
+	log := logrus.WithField("session", task.ID)
+
+	type item struct {
+		name   string
+		body   []byte
+		cursor string
+		err    error
+	}
+	ch := make(chan item, len(m.fetchers))
+	var wg sync.WaitGroup
+	for name, f := range m.fetchers {
+		wg.Add(1)
+		// each sub fetcher gets own copy of the task with its cursor
+		sub := *task
+		sub.Cursor, sub.NextCursor = task.SourceCursors[name], ""
+		sub.SourceCursors, sub.NextSourceCursors = nil, nil
+		go func(name string, f Fetcher, sub *FetcherTask) {
+			defer wg.Done()
+			body, err := f.Fetch(ctx, sub)
+			ch <- item{name: name, body: body, cursor: sub.NextCursor, err: err}
+		}(name, f, &sub)
+	}
+	wg.Wait()
+	close(ch)
+
+	var errs *multierror.Error
+	result := make(map[string][]byte, len(m.fetchers))
+	for i := range ch {
+		if i.err != nil {
+			log.Errorf("multi: %s failed for %s: %s", i.name, task.Target, i.err)
+			errs = multierror.Append(errs, errors.Wrap(i.err, i.name))
+			continue
+		}
+		result[i.name] = i.body
+		if i.cursor != "" {
+			if task.NextSourceCursors == nil {
+				task.NextSourceCursors = make(map[string]string)
+			}
+			task.NextSourceCursors[i.name] = i.cursor
+		}
+	}
+	if errs != nil && (m.RequireAll || len(result) == 0) {
+		if len(errs.Errors) == 1 {
+			// keep the failure reason of the only failed fetcher
+			return nil, errs.Errors[0]
+		}
+		return nil, errs
+	}
+	return result, nil
+}
+
+// Fetch return json object of sub fetchers payloads keyed by name
+func (m *multiFetcher) Fetch(ctx context.Context, task *FetcherTask) ([]byte, error) {
+	parts, err := m.FetchMulti(ctx, task)
+	if err != nil {
+		return nil, err
+	}
+	return EncodeMultiPayload(parts)
+}
+
+// EncodeMultiPayload encode named payloads to json object with string values
+func EncodeMultiPayload(parts map[string][]byte) ([]byte, error) {
+	combined := make(map[string]string, len(parts))
+	for name, body := range parts {
+		combined[name] = string(body)
+	}
+	return json.Marshal(combined)
+}
+
+// withRecordName name records of the sub fetcher by its name,
+// so sub fetchers of the same type do not overwrite records of each other
+func withRecordName(cfg repository.PluginConfig, name string) (repository.PluginConfig, error) {
+	rawRecord, ok := cfg[recordKey]
+	if !ok {
+		return cfg, nil
+	}
+	var record repository.PluginConfig
+	if err := decodeConfig(rawRecord, &record); err != nil {
+		return nil, errors.Wrap(err, "record")
+	}
+	if record == nil {
+		record = repository.PluginConfig{}
+	}
+	if _, ok := record["name"]; !ok {
+		record["name"] = name
+	}
+	result := make(repository.PluginConfig, len(cfg))
+	for k, v := range cfg {
+		result[k] = v
+	}
+	result[recordKey] = record
+	return result, nil
+}
@@ -0,0 +1,97 @@
+package fetchers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/combaine/combaine/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultiFetcher(t *testing.T) {
+	Register(testFetcherName, newTestFetcher)
+
+	var cfg repository.PluginConfig
+	plainConfig := repository.EncodedConfig(`
+fetchers:
+  first: {type: testFetcher}
+  second: {type: exec, command: [echo, -n]}
+  broken: {type: exec, command: ["false"]}`)
+	assert.NoError(t, plainConfig.Decode(&cfg))
+
+	f, err := NewFetcher("multi", cfg)
+	assert.NoError(t, err)
+	multi, ok := f.(MultiFetcher)
+	assert.True(t, ok)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	task := &FetcherTask{ID: "ID", Target: "host", Period: 60}
+
+	parts, err := multi.FetchMulti(ctx, task)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]byte{
+		"first":  []byte(testFetcherName),
+		"second": []byte("host 60"),
+	}, parts)
+
+	body, err := f.Fetch(ctx, task)
+	assert.NoError(t, err)
+	var combined map[string]string
+	assert.NoError(t, json.Unmarshal(body, &combined))
+	assert.Equal(t, map[string]string{"first": testFetcherName, "second": "host 60"}, combined)
+
+	cfg["require_all"] = true
+	f, err = NewFetcher("multi", cfg)
+	assert.NoError(t, err)
+	_, err = f.Fetch(ctx, task)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "broken")
+
+	badConfigs := []repository.PluginConfig{
+		{},
+		{"fetchers": map[string]interface{}{"sub": map[string]interface{}{"port": 80}}},
+		{"fetchers": map[string]interface{}{"sub": map[string]interface{}{"type": "not-registered"}}},
+		{"fetchers": map[string]interface{}{"sub": map[string]interface{}{"type": "multi"}}},
+		{"fetchers": map[string]interface{}{"sub": map[string]interface{}{"type": testFetcherName}},
+			"retry": map[string]interface{}{"attempts": 2}},
+	}
+	for _, c := range badConfigs {
+		_, err := NewFetcher("multi", c)
+		assert.Error(t, err, c)
+	}
+}
+
+// cursorFetcher is incremental fetcher which appends "+" to the cursor
+type cursorFetcher struct{}
+
+func (f *cursorFetcher) Fetch(ctx context.Context, task *FetcherTask) ([]byte, error) {
+	task.NextCursor = task.Cursor + "+"
+	return []byte(task.Cursor), nil
+}
+
+func TestMultiFetcherCursors(t *testing.T) {
+	Register("cursorFetcher", func(repository.PluginConfig) (Fetcher, error) { return &cursorFetcher{}, nil })
+
+	f, err := NewFetcher("multi", repository.PluginConfig{"fetchers": map[string]interface{}{
+		"access": map[string]interface{}{"type": "cursorFetcher"},
+		"error":  map[string]interface{}{"type": "cursorFetcher"},
+		"plain":  map[string]interface{}{"type": testFetcherName},
+	}})
+	assert.NoError(t, err)
+	multi := f.(MultiFetcher)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	task := &FetcherTask{ID: "ID", Target: "host", Period: 60,
+		SourceCursors: map[string]string{"access": "a", "error": "e"}}
+	parts, err := multi.FetchMulti(ctx, task)
+	assert.NoError(t, err)
+	assert.Equal(t, "a", string(parts["access"]))
+	assert.Equal(t, "e", string(parts["error"]))
+	assert.Equal(t, map[string]string{"access": "a+", "error": "e+"}, task.NextSourceCursors)
+	assert.Equal(t, "", task.NextCursor)
+	assert.Equal(t, map[string]string{"access": "a", "error": "e"}, task.SourceCursors)
+}
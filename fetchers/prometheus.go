@@ -0,0 +1,293 @@
+package fetchers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/combaine/combaine/repository"
+)
+
+func init() {
+	Register("prometheus", NewPrometheusFetcher)
+}
+
+// prometheusFetcher scrape metrics in the prometheus text exposition format
+// and return them as json list of samples
+type prometheusFetcher struct {
+	Port int    `mapstructure:"port"`
+	URI  string `mapstructure:"uri"`
+	// Metrics is list of regexps for metric family names
+	Metrics []string `mapstructure:"metrics"`
+	// Labels is map of label name to regexp for label value
+	Labels map[string]string `mapstructure:"labels"`
+
+	httpClientConfig `mapstructure:",squash"`
+
+	metrics []*regexp.Regexp
+	labels  map[string]*regexp.Regexp
+}
+
+// NewPrometheusFetcher return prometheus metrics fetcher
+func NewPrometheusFetcher(cfg repository.PluginConfig) (Fetcher, error) {
+	var fetcher prometheusFetcher
+	if err := decodeConfig(cfg, &fetcher); err != nil {
+		return nil, err
+	}
+	if fetcher.URI == "" {
+		fetcher.URI = "/metrics"
+	}
+	if fetcher.Port == 0 {
+		return nil, errors.New("prometheus: Missing option port")
+	}
+	if err := fetcher.setup(); err != nil {
+		return nil, fmt.Errorf("prometheus: %s", err)
+	}
+	for _, m := range fetcher.Metrics {
+		re, err := regexp.Compile("^(?:" + m + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("prometheus: bad metrics selector %q: %s", m, err)
+		}
+		fetcher.metrics = append(fetcher.metrics, re)
+	}
+	fetcher.labels = make(map[string]*regexp.Regexp, len(fetcher.Labels))
+	for name, l := range fetcher.Labels {
+		re, err := regexp.Compile("^(?:" + l + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("prometheus: bad label selector %s=%q: %s", name, l, err)
+		}
+		fetcher.labels[name] = re
+	}
+
+	return &fetcher, nil
+}
+
+func (t *prometheusFetcher) Fetch(ctx context.Context, task *FetcherTask) ([]byte, error) {
+	log := logrus.WithField("session", task.ID)
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return nil, errors.New("prometheus: Context without deadline")
+	}
+
+	url := fmt.Sprintf("%s://%s:%d%s", t.Scheme, task.Target, t.Port, t.URI)
+	log.Infof("prometheus: Requested URL: %s, timeout %v", url, deadline.Sub(time.Now()))
+
+	body, err := t.fetch(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	samples, err := parsePrometheusText(body)
+	if err != nil {
+		return nil, fmt.Errorf("prometheus: %s", err)
+	}
+	selected := samples[:0]
+	for _, s := range samples {
+		if t.match(s) {
+			selected = append(selected, s)
+		}
+	}
+	log.Infof("prometheus: Selected %d of %d samples from %s", len(selected), len(samples), url)
+	return json.Marshal(selected)
+}
+
+func (t *prometheusFetcher) match(s *PrometheusSample) bool {
+	if len(t.metrics) > 0 {
+		matched := false
+		for _, re := range t.metrics {
+			if re.MatchString(s.Family) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	for name, re := range t.labels {
+		if !re.MatchString(s.Labels[name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// PrometheusSample is one normalized sample of the metric family
+type PrometheusSample struct {
+	// Name of the sample, e.g. http_duration_seconds_bucket
+	Name string `json:"name"`
+	// Family name, e.g. http_duration_seconds
+	Family string `json:"family"`
+	// Type of the family: counter, gauge, histogram, summary or untyped
+	Type      string            `json:"type"`
+	Labels    map[string]string `json:"labels"`
+	Value     PrometheusValue   `json:"value"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+}
+
+// PrometheusValue is float64 encoded to json as number,
+// or as string "NaN", "+Inf" and "-Inf" for non finite values
+type PrometheusValue float64
+
+// MarshalJSON implement json.Marshaler
+func (v PrometheusValue) MarshalJSON() ([]byte, error) {
+	f := float64(v)
+	switch {
+	case math.IsNaN(f):
+		return []byte(`"NaN"`), nil
+	case math.IsInf(f, 1):
+		return []byte(`"+Inf"`), nil
+	case math.IsInf(f, -1):
+		return []byte(`"-Inf"`), nil
+	}
+	return []byte(strconv.FormatFloat(f, 'g', -1, 64)), nil
+}
+
+var prometheusSuffixes = map[string][]string{
+	"histogram": {"_bucket", "_sum", "_count"},
+	"summary":   {"_sum", "_count"},
+	"counter":   {"_total"},
+}
+
+// parsePrometheusText parse prometheus text exposition format
+func parsePrometheusText(body []byte) ([]*PrometheusSample, error) {
+	types := make(map[string]string)
+	var samples []*PrometheusSample
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if line[0] == '#' {
+			fields := strings.Fields(line)
+			if len(fields) >= 4 && fields[1] == "TYPE" {
+				types[fields[2]] = fields[3]
+			}
+			continue
+		}
+		s, err := parsePrometheusSample(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineno, err)
+		}
+		s.Family, s.Type = prometheusFamily(s.Name, types)
+		samples = append(samples, s)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+func prometheusFamily(name string, types map[string]string) (string, string) {
+	if t, ok := types[name]; ok {
+		return name, t
+	}
+	for t, suffixes := range prometheusSuffixes {
+		for _, suffix := range suffixes {
+			family := strings.TrimSuffix(name, suffix)
+			if family != name && types[family] == t {
+				return family, t
+			}
+		}
+	}
+	return name, "untyped"
+}
+
+func parsePrometheusSample(line string) (*PrometheusSample, error) {
+	s := &PrometheusSample{Labels: make(map[string]string)}
+
+	end := strings.IndexAny(line, "{ \t")
+	if end <= 0 {
+		return nil, fmt.Errorf("bad sample %q", line)
+	}
+	s.Name = line[:end]
+	rest := line[end:]
+	if rest[0] == '{' {
+		var err error
+		rest, err = parsePrometheusLabels(rest[1:], s.Labels)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("bad value in %q", line)
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return nil, fmt.Errorf("bad value in %q: %s", line, err)
+	}
+	s.Value = PrometheusValue(value)
+	if len(fields) == 2 {
+		if s.Timestamp, err = strconv.ParseInt(fields[1], 10, 64); err != nil {
+			return nil, fmt.Errorf("bad timestamp in %q: %s", line, err)
+		}
+	}
+	return s, nil
+}
+
+// parsePrometheusLabels parse labels after the opening brace
+// and return the rest of the line after the closing brace
+func parsePrometheusLabels(in string, labels map[string]string) (string, error) {
+	for {
+		in = strings.TrimLeft(in, " \t")
+		if in == "" {
+			return "", errors.New("unterminated label set")
+		}
+		if in[0] == '}' {
+			return in[1:], nil
+		}
+		eq := strings.IndexByte(in, '=')
+		if eq <= 0 {
+			return "", fmt.Errorf("bad label in %q", in)
+		}
+		name := strings.TrimSpace(in[:eq])
+		in = strings.TrimLeft(in[eq+1:], " \t")
+		if in == "" || in[0] != '"' {
+			return "", fmt.Errorf("label %s value is not quoted", name)
+		}
+		var value strings.Builder
+		i := 1
+		for ; i < len(in) && in[i] != '"'; i++ {
+			if in[i] == '\\' && i+1 < len(in) {
+				i++
+				switch in[i] {
+				case 'n':
+					value.WriteByte('\n')
+				default:
+					value.WriteByte(in[i])
+				}
+				continue
+			}
+			value.WriteByte(in[i])
+		}
+		if i == len(in) {
+			return "", fmt.Errorf("label %s value is not terminated", name)
+		}
+		labels[name] = value.String()
+		in = strings.TrimLeft(in[i+1:], " \t")
+		switch {
+		case in == "":
+		case in[0] == ',':
+			in = in[1:]
+		case in[0] != '}':
+			return "", fmt.Errorf("missing comma after label %s", name)
+		}
+	}
+}
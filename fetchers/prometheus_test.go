@@ -0,0 +1,146 @@
+package fetchers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/combaine/combaine/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+const prometheusPayload = `# HELP http_requests_total The total number of HTTP requests.
+# TYPE http_requests_total counter
+http_requests_total{method="post",code="200"} 1027 1395066363000
+http_requests_total{method="post",code="400"}    3 1395066363000
+
+# Escaping in label values:
+msdos_file_access_time_seconds{path="C:\\DIR\\FILE.TXT",error="Cannot find file:\n\"FILE.TXT\""} 1.458255915e9
+
+# Minimalistic line:
+metric_without_timestamp_and_labels 12.47
+
+# A histogram, which has a pretty complex representation in the text format:
+# HELP http_request_duration_seconds A histogram of the request duration.
+# TYPE http_request_duration_seconds histogram
+http_request_duration_seconds_bucket{le="0.05"} 24054
+http_request_duration_seconds_bucket{le="+Inf"} 144320
+http_request_duration_seconds_sum 53423
+http_request_duration_seconds_count 144320
+
+# Finally a summary, which has a complex representation, too:
+# HELP rpc_duration_seconds A summary of the RPC duration in seconds.
+# TYPE rpc_duration_seconds summary
+rpc_duration_seconds{quantile="0.5"} NaN
+rpc_duration_seconds{quantile="0.99"} +Inf
+rpc_duration_seconds_sum 1.7560473e+07
+rpc_duration_seconds_count 2693
+`
+
+func TestParsePrometheusText(t *testing.T) {
+	samples, err := parsePrometheusText([]byte(prometheusPayload))
+	assert.NoError(t, err)
+	assert.Len(t, samples, 12)
+
+	assert.Equal(t, &PrometheusSample{
+		Name: "http_requests_total", Family: "http_requests_total", Type: "counter",
+		Labels: map[string]string{"method": "post", "code": "400"}, Value: 3, Timestamp: 1395066363000,
+	}, samples[1])
+	assert.Equal(t, `C:\DIR\FILE.TXT`, samples[2].Labels["path"])
+	assert.Equal(t, "Cannot find file:\n\"FILE.TXT\"", samples[2].Labels["error"])
+	assert.Equal(t, "untyped", samples[3].Type)
+	assert.Empty(t, samples[3].Labels)
+
+	for _, s := range samples[4:8] {
+		assert.Equal(t, "http_request_duration_seconds", s.Family)
+		assert.Equal(t, "histogram", s.Type)
+	}
+	assert.Equal(t, "+Inf", samples[5].Labels["le"])
+	for _, s := range samples[8:] {
+		assert.Equal(t, "rpc_duration_seconds", s.Family)
+		assert.Equal(t, "summary", s.Type)
+	}
+	assert.True(t, math.IsNaN(float64(samples[8].Value)))
+
+	data, err := json.Marshal(samples[8:10])
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"value":"NaN"`)
+	assert.Contains(t, string(data), `"value":"+Inf"`)
+
+	bad := []string{
+		`metric{label="value} 1`,
+		`metric{label=value} 1`,
+		`metric{label="value"}`,
+		`metric 1 2 3`,
+		`metric one`,
+		`metric 1 now`,
+		`{label="value"} 1`,
+		`metric{a="1"b="2"} 1`,
+	}
+	for _, line := range bad {
+		_, err := parsePrometheusText([]byte(line))
+		assert.Error(t, err, line)
+	}
+}
+
+func TestPrometheusFetcherConfig(t *testing.T) {
+	f, err := NewPrometheusFetcher(repository.PluginConfig{"port": 9100})
+	assert.NoError(t, err)
+	assert.Equal(t, "/metrics", f.(*prometheusFetcher).URI)
+
+	bad := []repository.PluginConfig{
+		{},
+		{"port": 9100, "metrics": []string{"("}},
+		{"port": 9100, "labels": map[string]string{"code": "["}},
+	}
+	for _, c := range bad {
+		_, err := NewPrometheusFetcher(c)
+		assert.Error(t, err, fmt.Sprintf("%v", c))
+	}
+}
+
+func TestPrometheusFetcherFetch(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metrics" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, prometheusPayload)
+	}))
+	defer ts.Close()
+	target, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+	cases := []struct {
+		config   repository.PluginConfig
+		expected int
+	}{
+		{repository.PluginConfig{}, 12},
+		{repository.PluginConfig{"metrics": []string{"http_.*"}}, 6},
+		{repository.PluginConfig{"metrics": []string{"http_requests_total"}, "labels": map[string]string{"code": "2.."}}, 1},
+		{repository.PluginConfig{"labels": map[string]string{"le": `\+Inf`}}, 1},
+		{repository.PluginConfig{"metrics": []string{"rpc_duration_seconds", "metric_without_.*"}}, 5},
+	}
+	for _, c := range cases {
+		c.config["port"] = port
+		f, err := NewPrometheusFetcher(c.config)
+		assert.NoError(t, err)
+
+		_, err = f.Fetch(context.Background(), &FetcherTask{ID: "ID", Target: target})
+		assertErrorIfContextWithoutDeadline(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		body, err := f.Fetch(ctx, &FetcherTask{ID: "ID", Target: target})
+		cancel()
+		assert.NoError(t, err)
+
+		var samples []map[string]interface{}
+		assert.NoError(t, json.Unmarshal(body, &samples))
+		assert.Len(t, samples, c.expected, fmt.Sprintf("%v", c.config))
+	}
+}
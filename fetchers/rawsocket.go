@@ -48,5 +48,16 @@ func (t *tcpSocketFetcher) Fetch(ctx context.Context, task *FetcherTask) ([]byte
 	if err != nil {
 		return nil, err
 	}
-	return ioutil.ReadAll(conn)
+	defer conn.Close()
+	body, err := ioutil.ReadAll(conn)
+	if err != nil {
+		return nil, err
+	}
+	if encoding := detectEncoding(body); encoding != "" {
+		log.Debugf("rawsocket: Decompress %s payload from %s", encoding, address)
+		if body, err = decompress(encoding, body, 0); err != nil {
+			return nil, &FetchError{Reason: ReasonDecompress, URL: address, Err: err}
+		}
+	}
+	return body, nil
 }
@@ -0,0 +1,148 @@
+package fetchers
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/combaine/combaine/repository"
+)
+
+const (
+	recordKey         = "record"
+	defaultRecordKeep = 60
+)
+
+// RecordConfig is the `record` subsection of the DataFetcher,
+// every fetched blob is stored to Dir/<config>/<target>/<name>/<start>-<end>
+type RecordConfig struct {
+	Dir string `mapstructure:"dir"`
+	// Name of the recorded fetcher, the fetcher type by default
+	Name string `mapstructure:"name"`
+	// Keep is number of the last recorded frames per config, target and name
+	Keep int `mapstructure:"keep"`
+}
+
+type recordFetcher struct {
+	Fetcher
+	cfg RecordConfig
+}
+
+// NewRecordFetcher wrap fetcher with recorder of fetched blobs
+func NewRecordFetcher(f Fetcher, cfg RecordConfig) (Fetcher, error) {
+	if cfg.Dir == "" {
+		return nil, fmt.Errorf("record: Missing option dir")
+	}
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("record: Missing option name")
+	}
+	if cfg.Keep <= 0 {
+		cfg.Keep = defaultRecordKeep
+	}
+	return &recordFetcher{Fetcher: f, cfg: cfg}, nil
+}
+
+// newRecordFetcherFromConfig wrap fetcher if the `record` subsection is present
+func newRecordFetcherFromConfig(f Fetcher, name string, cfg repository.PluginConfig) (Fetcher, error) {
+	rawRecord, ok := cfg[recordKey]
+	if !ok {
+		return f, nil
+	}
+	recordCfg := RecordConfig{Name: name}
+	if err := decodeConfig(rawRecord, &recordCfg); err != nil {
+		return nil, fmt.Errorf("record: %s", err)
+	}
+	return NewRecordFetcher(f, recordCfg)
+}
+
+// Stateful is true, records are kept per parsing config and target
+func (r *recordFetcher) Stateful() bool {
+	return true
+}
+
+// Fetch call wrapped fetcher and store the result,
+// a failed recording does not fail the fetch
+func (r *recordFetcher) Fetch(ctx context.Context, task *FetcherTask) ([]byte, error) {
+	body, err := r.Fetcher.Fetch(ctx, task)
+	if err != nil {
+		return body, err
+	}
+
+	log := logrus.WithField("session", task.ID)
+	dir := recordDir(r.cfg.Dir, task.Config, task.Target, r.cfg.Name)
+	path := filepath.Join(dir, recordFrame(task.Start, task.End))
+	if err := writeFileAtomic(path, body); err != nil {
+		log.Errorf("record: Failed to record %s: %s", path, err)
+		return body, nil
+	}
+	log.Debugf("record: Recorded %d bytes to %s", len(body), path)
+	if err := pruneRecords(dir, r.cfg.Keep); err != nil {
+		log.Errorf("record: Failed to prune records in %s: %s", dir, err)
+	}
+	return body, nil
+}
+
+func recordDir(dir, config, target, name string) string {
+	clean := func(s string) string {
+		return strings.Replace(s, string(filepath.Separator), "_", -1)
+	}
+	return filepath.Join(dir, clean(config), clean(target), clean(name))
+}
+
+func recordFrame(start, end int64) string {
+	return fmt.Sprintf("%d-%d", start, end)
+}
+
+// listRecords return recorded frames in dir sorted by the frame start
+func listRecords(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type frame struct {
+		name  string
+		start int64
+	}
+	var frames []frame
+	for _, f := range files {
+		parts := strings.SplitN(f.Name(), "-", 2)
+		if f.IsDir() || len(parts) != 2 {
+			continue
+		}
+		start, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		if _, err := strconv.ParseInt(parts[1], 10, 64); err != nil {
+			continue
+		}
+		frames = append(frames, frame{name: f.Name(), start: start})
+	}
+	sort.Slice(frames, func(i, j int) bool { return frames[i].start < frames[j].start })
+	names := make([]string, len(frames))
+	for i, f := range frames {
+		names[i] = f.name
+	}
+	return names, nil
+}
+
+func pruneRecords(dir string, keep int) error {
+	names, err := listRecords(dir)
+	if err != nil {
+		return err
+	}
+	for len(names) > keep {
+		if err := os.Remove(filepath.Join(dir, names[0])); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
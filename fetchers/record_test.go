@@ -0,0 +1,66 @@
+package fetchers
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/combaine/combaine/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "combaine-record")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	Register(testFetcherName, newTestFetcher)
+	f, err := NewFetcher(testFetcherName, repository.PluginConfig{
+		"record": map[string]interface{}{"dir": dir, "keep": 2},
+	})
+	assert.NoError(t, err)
+	assert.IsType(t, &recordFetcher{}, f)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for _, start := range []int64{100, 160, 220} {
+		task := &FetcherTask{ID: "ID", Config: "conf/name", Target: "host", Start: start, End: start + 60}
+		body, err := f.Fetch(ctx, task)
+		assert.NoError(t, err)
+		assert.Equal(t, testFetcherName, string(body))
+	}
+	recorded := recordDir(dir, "conf/name", "host", testFetcherName)
+	names, err := listRecords(recorded)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"160-220", "220-280"}, names)
+	assert.Equal(t, filepath.Join(dir, "conf_name", "host", testFetcherName), recorded)
+
+	replay, err := NewFetcher("replay", repository.PluginConfig{"dir": dir, "name": testFetcherName})
+	assert.NoError(t, err)
+	task := &FetcherTask{ID: "ID", Config: "conf/name", Target: "host", Start: 160, End: 220}
+	body, err := replay.Fetch(ctx, task)
+	assert.NoError(t, err)
+	assert.Equal(t, testFetcherName, string(body))
+
+	task = &FetcherTask{ID: "ID", Config: "other", Target: "host", Start: 1000, End: 1060}
+	_, err = replay.Fetch(ctx, task)
+	assert.Error(t, err)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(recorded, "220-280"), []byte("latest"), 0644))
+	replay, err = NewFetcher("replay", repository.PluginConfig{
+		"dir": dir, "name": testFetcherName, "config": "conf/name", "latest": true})
+	assert.NoError(t, err)
+	body, err = replay.Fetch(ctx, task)
+	assert.NoError(t, err)
+	assert.Equal(t, "latest", string(body))
+
+	for _, cfg := range []repository.PluginConfig{{}, {"dir": dir}, {"name": "http"}} {
+		_, err := NewReplayFetcher(cfg)
+		assert.Error(t, err, cfg)
+	}
+	_, err = NewFetcher(testFetcherName, repository.PluginConfig{"record": map[string]interface{}{}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,77 @@
+package fetchers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/combaine/combaine/repository"
+)
+
+func init() {
+	Register("replay", NewReplayFetcher)
+}
+
+// replayFetcher serve blobs stored by the `record` decorator
+type replayFetcher struct {
+	Dir string `mapstructure:"dir"`
+	// Name of the recorded fetcher
+	Name string `mapstructure:"name"`
+	// Config overrides the name of the recorded parsing config
+	Config string `mapstructure:"config"`
+	// Latest serves the last recorded frame if the task frame is not recorded
+	Latest bool `mapstructure:"latest"`
+}
+
+// NewReplayFetcher return replay data fetcher
+func NewReplayFetcher(cfg repository.PluginConfig) (Fetcher, error) {
+	var f replayFetcher
+	if err := decodeConfig(cfg, &f); err != nil {
+		return nil, err
+	}
+	if f.Dir == "" {
+		return nil, errors.New("replay: Missing option dir")
+	}
+	if f.Name == "" {
+		return nil, errors.New("replay: Missing option name")
+	}
+	return &f, nil
+}
+
+// Fetch read recorded blob for config, target and frame of the task
+func (r *replayFetcher) Fetch(ctx context.Context, task *FetcherTask) ([]byte, error) {
+	log := logrus.WithField("session", task.ID)
+
+	config := r.Config
+	if config == "" {
+		config = task.Config
+	}
+	dir := recordDir(r.Dir, config, task.Target, r.Name)
+	frame := recordFrame(task.Start, task.End)
+	body, err := ioutil.ReadFile(filepath.Join(dir, frame))
+	if err == nil || !r.Latest {
+		if err != nil {
+			return nil, fmt.Errorf("replay: %s", err)
+		}
+		log.Infof("replay: Replayed %s/%s", dir, frame)
+		return body, nil
+	}
+
+	names, err := listRecords(dir)
+	if err != nil {
+		return nil, fmt.Errorf("replay: %s", err)
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("replay: No records in %s", dir)
+	}
+	frame = names[len(names)-1]
+	if body, err = ioutil.ReadFile(filepath.Join(dir, frame)); err != nil {
+		return nil, fmt.Errorf("replay: %s", err)
+	}
+	log.Infof("replay: Replayed latest %s/%s", dir, frame)
+	return body, nil
+}
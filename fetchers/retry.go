@@ -0,0 +1,143 @@
+package fetchers
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/combaine/combaine/repository"
+)
+
+const retryKey = "retry"
+
+var defaultRetryOn = []string{ReasonNetwork, ReasonStatus, ReasonBodyTruncated}
+
+// RetryConfig is retry policy from the `retry` subsection of the DataFetcher
+type RetryConfig struct {
+	// Attempts is total number of attempts including the first one
+	Attempts int `mapstructure:"attempts"`
+	// Backoff is delay in milliseconds before the second attempt,
+	// it is doubled for each next attempt
+	Backoff int64 `mapstructure:"backoff"`
+	// MaxBackoff in milliseconds limits the delay between attempts
+	MaxBackoff int64 `mapstructure:"max_backoff"`
+	// Jitter is fraction of the delay which is randomized, from 0 to 1
+	Jitter float64 `mapstructure:"jitter"`
+	// RetryOn is list of retryable failure reasons,
+	// by default network, status (only 5xx) and body_truncated
+	RetryOn []string `mapstructure:"retry_on"`
+}
+
+type retryFetcher struct {
+	Fetcher
+	cfg     RetryConfig
+	retryOn map[string]bool
+}
+
+// Stateful report the state of the wrapped fetcher
+func (r *retryFetcher) Stateful() bool {
+	return IsStateful(r.Fetcher)
+}
+
+// NewRetryFetcher wrap fetcher with retry policy
+func NewRetryFetcher(f Fetcher, cfg RetryConfig) (Fetcher, error) {
+	if cfg.Attempts <= 0 {
+		cfg.Attempts = 1
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = 100
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = 10 * cfg.Backoff
+	}
+	if cfg.Jitter < 0 || cfg.Jitter > 1 {
+		return nil, fmt.Errorf("retry: jitter must be in range [0, 1], got %v", cfg.Jitter)
+	}
+	if len(cfg.RetryOn) == 0 {
+		cfg.RetryOn = defaultRetryOn
+	}
+	retryOn := make(map[string]bool, len(cfg.RetryOn))
+	for _, reason := range cfg.RetryOn {
+		switch reason {
+		case ReasonNetwork, ReasonStatus, ReasonBodyTooLarge, ReasonBodyTruncated,
+			ReasonDecompress, ReasonOther:
+			retryOn[reason] = true
+		default:
+			return nil, fmt.Errorf("retry: unknown reason %q in retry_on", reason)
+		}
+	}
+	return &retryFetcher{Fetcher: f, cfg: cfg, retryOn: retryOn}, nil
+}
+
+// newRetryFetcherFromConfig wrap fetcher if the `retry` subsection is present
+func newRetryFetcherFromConfig(f Fetcher, cfg repository.PluginConfig) (Fetcher, error) {
+	rawRetry, ok := cfg[retryKey]
+	if !ok {
+		return f, nil
+	}
+	var retryCfg RetryConfig
+	if err := decodeConfig(rawRetry, &retryCfg); err != nil {
+		return nil, fmt.Errorf("retry: %s", err)
+	}
+	return NewRetryFetcher(f, retryCfg)
+}
+
+// Fetch call wrapped fetcher until success, not retryable error,
+// exhausted attempts or until the next attempt does not fit in the deadline
+func (r *retryFetcher) Fetch(ctx context.Context, task *FetcherTask) ([]byte, error) {
+	log := logrus.WithField("session", task.ID)
+
+	var (
+		body []byte
+		err  error
+	)
+	for attempt := 1; ; attempt++ {
+		body, err = r.Fetcher.Fetch(ctx, task)
+		if err == nil || attempt >= r.cfg.Attempts || !r.retryable(err) {
+			return body, err
+		}
+		delay := r.delay(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			log.Infof("retry: No time left for attempt %d to %s: %s", attempt+1, task.Target, err)
+			return body, err
+		}
+		log.Warnf("retry: Attempt %d to %s failed (%s), retry in %v", attempt, task.Target, err, delay)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (r *retryFetcher) retryable(err error) bool {
+	reason := ErrorReason(err)
+	if !r.retryOn[reason] {
+		return false
+	}
+	if fe, ok := errors.Cause(err).(*FetchError); ok && reason == ReasonStatus {
+		return fe.Temporary()
+	}
+	return true
+}
+
+// delay return exponential backoff with jitter for attempt
+func (r *retryFetcher) delay(attempt int) time.Duration {
+	backoff := r.cfg.Backoff
+	for i := 1; i < attempt && backoff < r.cfg.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > r.cfg.MaxBackoff {
+		backoff = r.cfg.MaxBackoff
+	}
+	delay := time.Duration(backoff) * time.Millisecond
+	if r.cfg.Jitter > 0 {
+		jitter := time.Duration(float64(delay) * r.cfg.Jitter)
+		delay = delay - jitter + time.Duration(rand.Int63n(int64(jitter)+1))
+	}
+	return delay
+}
@@ -0,0 +1,116 @@
+package fetchers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/combaine/combaine/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+type flakyFetcher struct {
+	errs  []error
+	calls int
+}
+
+func (f *flakyFetcher) Fetch(ctx context.Context, task *FetcherTask) ([]byte, error) {
+	f.calls++
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		return nil, err
+	}
+	return []byte("ok"), nil
+}
+
+func TestRetryFetcher(t *testing.T) {
+	connReset := &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}
+	status500 := &FetchError{Reason: ReasonStatus, StatusCode: 500}
+	status404 := &FetchError{Reason: ReasonStatus, StatusCode: 404}
+
+	cases := []struct {
+		name  string
+		cfg   RetryConfig
+		errs  []error
+		calls int
+		fail  bool
+	}{
+		{"success", RetryConfig{Attempts: 3}, nil, 1, false},
+		{"recovered", RetryConfig{Attempts: 3}, []error{connReset, io.ErrUnexpectedEOF}, 3, false},
+		{"exhausted", RetryConfig{Attempts: 2}, []error{connReset, status500}, 2, true},
+		{"not temporary status", RetryConfig{Attempts: 3}, []error{status404}, 1, true},
+		{"not retryable", RetryConfig{Attempts: 3}, []error{errors.New("bad config")}, 1, true},
+		{"deadline", RetryConfig{Attempts: 3}, []error{context.DeadlineExceeded}, 1, true},
+		{"retry on other", RetryConfig{Attempts: 3, RetryOn: []string{ReasonOther}}, []error{errors.New("any")}, 2, false},
+		{"retry_on filter", RetryConfig{Attempts: 3, RetryOn: []string{ReasonStatus}}, []error{connReset}, 1, true},
+		{"no time left", RetryConfig{Attempts: 3, Backoff: 5000}, []error{connReset}, 1, true},
+	}
+	for _, c := range cases {
+		if c.cfg.Backoff == 0 {
+			c.cfg.Backoff = 1
+		}
+		c.cfg.Jitter = 0.5
+		ff := &flakyFetcher{errs: c.errs}
+		f, err := NewRetryFetcher(ff, c.cfg)
+		assert.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		body, err := f.Fetch(ctx, &FetcherTask{ID: "ID", Target: "host"})
+		cancel()
+		assert.Equal(t, c.calls, ff.calls, c.name)
+		if c.fail {
+			assert.Error(t, err, c.name)
+		} else {
+			assert.NoError(t, err, c.name)
+			assert.Equal(t, "ok", string(body), c.name)
+		}
+	}
+
+	_, err := NewRetryFetcher(&flakyFetcher{}, RetryConfig{Jitter: 2})
+	assert.Error(t, err)
+	_, err = NewRetryFetcher(&flakyFetcher{}, RetryConfig{RetryOn: []string{"unknown"}})
+	assert.Error(t, err)
+}
+
+func TestRetryFetcherDelay(t *testing.T) {
+	f, err := NewRetryFetcher(&flakyFetcher{}, RetryConfig{Backoff: 100, MaxBackoff: 500})
+	assert.NoError(t, err)
+	r := f.(*retryFetcher)
+	expected := []time.Duration{100, 200, 400, 500, 500}
+	for i, e := range expected {
+		assert.Equal(t, e*time.Millisecond, r.delay(i+1))
+	}
+
+	f, err = NewRetryFetcher(&flakyFetcher{}, RetryConfig{Backoff: 100, Jitter: 0.5})
+	assert.NoError(t, err)
+	r = f.(*retryFetcher)
+	for i := 0; i < 100; i++ {
+		d := r.delay(2)
+		assert.True(t, d >= 100*time.Millisecond && d <= 200*time.Millisecond, d)
+	}
+}
+
+func TestNewFetcherWithRetry(t *testing.T) {
+	Register(testFetcherName, newTestFetcher)
+	f, err := NewFetcher(testFetcherName, repository.PluginConfig{})
+	assert.NoError(t, err)
+	assert.IsType(t, &testFetcher{}, f)
+
+	var cfg repository.PluginConfig
+	plainConfig := repository.EncodedConfig("retry: {attempts: 3, backoff: 50, retry_on: [network]}")
+	assert.NoError(t, plainConfig.Decode(&cfg))
+	f, err = NewFetcher(testFetcherName, cfg)
+	assert.NoError(t, err)
+	r, ok := f.(*retryFetcher)
+	assert.True(t, ok)
+	assert.Equal(t, 3, r.cfg.Attempts)
+	assert.EqualValues(t, 50, r.cfg.Backoff)
+	assert.Equal(t, map[string]bool{ReasonNetwork: true}, r.retryOn)
+
+	_, err = NewFetcher(testFetcherName, repository.PluginConfig{"retry": "bad"})
+	assert.Error(t, err)
+}
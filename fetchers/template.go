@@ -0,0 +1,38 @@
+package fetchers
+
+import (
+	"bytes"
+	"strings"
+	"text/template"
+
+	"github.com/combaine/combaine/repository"
+)
+
+// templateData is available in templated fetcher options as
+// {{.Target}}, {{.Datacenter}}, {{.Period}}, {{.Start}}, {{.End}}, {{.ID}},
+// {{.Port}} and raw fetcher options as {{.Options.name}}
+type templateData struct {
+	*FetcherTask
+	Port    int
+	Options repository.PluginConfig
+}
+
+// parseTemplate return nil template if the option has no actions
+func parseTemplate(name, text string) (*template.Template, error) {
+	if !strings.Contains(text, "{{") {
+		return nil, nil
+	}
+	return template.New(name).Option("missingkey=error").Parse(text)
+}
+
+// renderTemplate execute tmpl or return fallback if tmpl is nil
+func renderTemplate(tmpl *template.Template, fallback string, data *templateData) (string, error) {
+	if tmpl == nil {
+		return fallback, nil
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -1,26 +1,54 @@
 package fetchers
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"net/url"
+	"strconv"
+	"text/template"
 	"time"
 
 	"github.com/sirupsen/logrus"
 
-	"github.com/combaine/combaine/common/chttp"
 	"github.com/combaine/combaine/repository"
 )
 
+const (
+	timetailCursorParam        = "log_ts"
+	defaultTimetailCursorField = "log_ts"
+	// defaultTimetailCursorPeriods limits how many periods before the frame
+	// start the numeric cursor can point to
+	defaultTimetailCursorPeriods = 5
+)
+
 func init() {
 	Register("timetail", NewTimetailFetcher)
 }
 
 type timetailFetcher struct {
-	Port    int    `mapstructure:"timetail_port"`
-	URL     string `mapstructure:"timetail_url"`
-	Logname string `mapstructure:"logname"`
+	Port int `mapstructure:"timetail_port"`
+	// URL is followed by logname and time arguments,
+	// if URL is go template (see templateData) it is used as is
+	URL         string `mapstructure:"timetail_url"`
+	Logname     string `mapstructure:"logname"`
+	ConnectHost string `mapstructure:"connect_host"`
+	// Incremental requests lines after the cursor of the previous iteration
+	// in the log_ts argument instead of the time window,
+	// the cursor is the CursorField of the last fetched tskv line.
+	// Cursors are kept by the combainer owning the config, after the config
+	// is moved to another combainer the time window is requested again
+	Incremental bool   `mapstructure:"incremental"`
+	CursorField string `mapstructure:"cursor_field"`
+	// CursorPeriods is max age of numeric cursor in periods, older cursor
+	// is moved forward, so the fetcher does not request hours of backlog
+	CursorPeriods int64 `mapstructure:"cursor_periods"`
+
+	httpClientConfig `mapstructure:",squash"`
+	urlTmpl          *template.Template
+	hostTmpl         *template.Template
+	options          repository.PluginConfig
 }
 
 // NewTimetailFetcher build new timetail fetcher
@@ -33,27 +61,114 @@ func NewTimetailFetcher(cfg repository.PluginConfig) (Fetcher, error) {
 	if fetcher.Port == 0 {
 		return nil, errors.New("timetail: Missing option port")
 	}
+	if fetcher.CursorField == "" {
+		fetcher.CursorField = defaultTimetailCursorField
+	}
+	if fetcher.CursorPeriods < 0 {
+		return nil, errors.New("timetail: cursor_periods must not be negative")
+	}
+	if fetcher.CursorPeriods == 0 {
+		fetcher.CursorPeriods = defaultTimetailCursorPeriods
+	}
+	if err := fetcher.setup(); err != nil {
+		return nil, fmt.Errorf("timetail: %s", err)
+	}
+	var err error
+	if fetcher.urlTmpl, err = parseTemplate("timetail_url", fetcher.URL); err != nil {
+		return nil, fmt.Errorf("timetail: timetail_url: %s", err)
+	}
+	if fetcher.hostTmpl, err = parseTemplate("connect_host", fetcher.ConnectHost); err != nil {
+		return nil, fmt.Errorf("timetail: connect_host: %s", err)
+	}
+	fetcher.options = cfg
 
 	return &fetcher, nil
 }
 
+// Stateful is true for incremental fetcher, it continues from the cursor
+// of the previous iteration of the parsing config and target
+func (t *timetailFetcher) Stateful() bool {
+	return t.Incremental
+}
+
 func (t *timetailFetcher) Fetch(ctx context.Context, task *FetcherTask) ([]byte, error) {
 	log := logrus.WithField("session", task.ID)
 
-	url := fmt.Sprintf("http://%s:%d%s%s&time=%d", task.Target, t.Port, t.URL, t.Logname, task.Period)
+	data := &templateData{FetcherTask: task, Port: t.Port, Options: t.options}
+	host := t.ConnectHost
+	if host == "" {
+		host = task.Target
+	}
+	host, err := renderTemplate(t.hostTmpl, host, data)
+	if err != nil {
+		return nil, fmt.Errorf("timetail: connect_host: %s", err)
+	}
+	path := fmt.Sprintf("%s%s&time=%d", t.URL, t.Logname, task.Period)
+	if path, err = renderTemplate(t.urlTmpl, path, data); err != nil {
+		return nil, fmt.Errorf("timetail: timetail_url: %s", err)
+	}
+
+	url := fmt.Sprintf("%s://%s:%d%s", t.Scheme, host, t.Port, path)
+	if t.Incremental && task.Cursor != "" {
+		cursor := clampCursor(task.Cursor, task.Start-t.CursorPeriods*task.Period)
+		if url, err = withTimetailCursor(url, cursor); err != nil {
+			return nil, fmt.Errorf("timetail: %s", err)
+		}
+	}
 	deadline, ok := ctx.Deadline()
 	if !ok {
 		return nil, errors.New("timetail: Context without deadline")
 	}
-	log.Infof("timetail: Requested URL: %s, timeout %v", url, deadline.Sub(time.Now()))
+	log.Infof("timetail: Requested URL: %s %s, timeout %v", t.Method, url, deadline.Sub(time.Now()))
 
-	resp, err := chttp.Get(ctx, url)
+	body, err := t.fetch(ctx, url)
 	if err != nil {
+		log.Infof("timetail: Failed URL %s: %s", url, err)
 		return nil, err
 	}
-	defer resp.Body.Close()
+	log.Infof("timetail: Result for URL %s: %d bytes", url, len(body))
+	if t.Incremental {
+		task.NextCursor = task.Cursor
+		if cursor := lastTskvField(body, t.CursorField); cursor != "" {
+			task.NextCursor = cursor
+		}
+	}
+	return body, nil
+}
 
-	log.Infof("timetail: Result for URL %s: %d", url, resp.StatusCode)
-	body, err := ioutil.ReadAll(resp.Body)
-	return body, err
+// withTimetailCursor replace the time window in rawURL with the cursor
+func withTimetailCursor(rawURL, cursor string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Del("time")
+	q.Set(timetailCursorParam, cursor)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// clampCursor move numeric cursor older than min to min,
+// non numeric cursors are returned as is
+func clampCursor(cursor string, min int64) string {
+	ts, err := strconv.ParseInt(cursor, 10, 64)
+	if err != nil || ts >= min {
+		return cursor
+	}
+	return strconv.FormatInt(min, 10)
+}
+
+// lastTskvField return value of the field in the last tskv line which has it
+func lastTskvField(body []byte, field string) string {
+	prefix := []byte(field + "=")
+	lines := bytes.Split(bytes.TrimRight(body, "\n"), []byte("\n"))
+	for i := len(lines) - 1; i >= 0; i-- {
+		for _, kv := range bytes.Split(lines[i], []byte("\t")) {
+			if bytes.HasPrefix(kv, prefix) {
+				return string(kv[len(prefix):])
+			}
+		}
+	}
+	return ""
 }
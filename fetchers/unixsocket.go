@@ -0,0 +1,134 @@
+package fetchers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/combaine/combaine/repository"
+)
+
+func init() {
+	Register("unixsocket", NewUnixSocketFetcher)
+}
+
+// unixSocketFetcher read data from local unix socket,
+// if uri is configured the http request is sent over the socket
+type unixSocketFetcher struct {
+	// Path to the socket, `%s` is replaced with the target host
+	Path string `mapstructure:"path"`
+	// URI of the http request, raw mode is used if empty
+	URI string `mapstructure:"uri"`
+	// Request is written to the socket before reading in raw mode
+	Request string `mapstructure:"request"`
+
+	httpClientConfig `mapstructure:",squash"`
+	d                net.Dialer
+}
+
+// unixHTTPClients are keyed by configured socket path, so templated paths
+// share one client, requests are pooled by the target host of the url
+var unixHTTPClients = struct {
+	sync.Mutex
+	m map[string]*http.Client
+}{m: make(map[string]*http.Client)}
+
+func unixHTTPClient(path string) *http.Client {
+	unixHTTPClients.Lock()
+	defer unixHTTPClients.Unlock()
+	if client, ok := unixHTTPClients.m[path]; ok {
+		return client
+	}
+	var d net.Dialer
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+			return d.DialContext(ctx, "unix", socketPath(path, addr))
+		},
+		MaxIdleConns:    10,
+		IdleConnTimeout: 90 * time.Second,
+	}}
+	unixHTTPClients.m[path] = client
+	return client
+}
+
+// socketPath replace `%s` in the path with the host of addr
+func socketPath(path, addr string) string {
+	if !strings.Contains(path, `%s`) {
+		return path
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return fmt.Sprintf(path, host)
+}
+
+// NewUnixSocketFetcher return unix socket data fetcher
+func NewUnixSocketFetcher(cfg repository.PluginConfig) (Fetcher, error) {
+	var f unixSocketFetcher
+	if err := decodeConfig(cfg, &f); err != nil {
+		return nil, err
+	}
+	if f.Path == "" {
+		return nil, errors.New("unixsocket: Missing option path")
+	}
+	if err := f.setup(); err != nil {
+		return nil, fmt.Errorf("unixsocket: %s", err)
+	}
+	if f.Scheme != "http" {
+		return nil, errors.New("unixsocket: Only http scheme is supported")
+	}
+	return &f, nil
+}
+
+// Fetch read data from the socket or send http request over it
+func (t *unixSocketFetcher) Fetch(ctx context.Context, task *FetcherTask) ([]byte, error) {
+	log := logrus.WithField("session", task.ID)
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return nil, errors.New("unixsocket: Context without deadline")
+	}
+	path := socketPath(t.Path, task.Target)
+
+	if t.URI != "" {
+		// host part is only used for Host header
+		url := fmt.Sprintf("http://%s%s", task.Target, t.URI)
+		log.Infof("unixsocket: Requested URL: %s %s via %s, timeout %v",
+			t.Method, url, path, deadline.Sub(time.Now()))
+		fetcher := t.httpClientConfig
+		fetcher.client = unixHTTPClient(t.Path)
+		return fetcher.fetch(ctx, url)
+	}
+
+	log.Infof("unixsocket: Requested socket: %s, timeout %v", path, deadline.Sub(time.Now()))
+	conn, err := t.d.DialContext(ctx, "unix", path)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(deadline)
+	if t.Request != "" {
+		if _, err := conn.Write([]byte(t.Request)); err != nil {
+			return nil, err
+		}
+	}
+	body, err := ioutil.ReadAll(conn)
+	if err != nil {
+		return nil, err
+	}
+	if encoding := detectEncoding(body); encoding != "" {
+		if body, err = decompress(encoding, body, 0); err != nil {
+			return nil, &FetchError{Reason: ReasonDecompress, URL: path, Err: err}
+		}
+	}
+	return body, nil
+}
@@ -17,12 +17,20 @@ type MainSection struct {
 	HostFetcher PluginConfig `yaml:"HostFetcher,omitempty"`
 	// Cache TTLCache options
 	Cache CacheConfig `yaml:"cache,omitempty"`
+	// CursorDir is where cursors of incremental fetchers are saved,
+	// it is local to the combainer, cursors are not moved with reassigned configs
+	CursorDir string `yaml:"cursor_dir,omitempty"`
 }
 
 // CacheConfig for TTLCache
 type CacheConfig struct {
 	TTL      int64 `yaml:"ttl"`
 	Interval int64 `yaml:"interval"`
+	// MaxStale in minutes is how long the last fetched hosts are used
+	// when discovery fails, negative value disables it
+	MaxStale int64 `yaml:"max_stale"`
+	// Snapshot is file where the cache is saved every interval
+	Snapshot string `yaml:"snapshot,omitempty"`
 }
 
 // ClusterConfig about serf and raft
@@ -59,6 +67,9 @@ type AggregationConfig struct {
 	Data map[string]PluginConfig `yaml:"data"`
 	// Configuration of possible senders
 	Senders map[string]PluginConfig `yaml:"senders"`
+	// Labels of hosts added to meta of aggregator tasks and so to tags of senders,
+	// other discovered labels are used only for aggregation levels
+	Labels []string `yaml:"labels"`
 }
 
 // ParsingConfig contains settings from parsing section of combainer configs
@@ -75,6 +86,17 @@ type ParsingConfig struct {
 	MainSection `yaml:"Combainer"`
 	// Overrides the same section in combainer.yaml
 	HostFetcher PluginConfig `yaml:"HostFetcher,omitempty"`
+	// HostsExpr is expression over groups used instead of Groups,
+	// e.g. `groupA + groupB - maintenance`, see hosts.Eval.
+	// Group `name:group` is resolved by the named fetcher from HostFetchers
+	// if the name is defined there, otherwise by the default fetcher.
+	// Unlike Groups, a failed group fails the whole expression
+	HostsExpr string `yaml:"hosts_expr,omitempty"`
+	// HostFetchers are additional named hosts fetchers for HostsExpr
+	HostFetchers map[string]PluginConfig `yaml:"HostFetchers,omitempty"`
+	// IncludeHosts and ExcludeHosts are regexps filtering resolved hosts
+	IncludeHosts []string `yaml:"include_hosts,omitempty"`
+	ExcludeHosts []string `yaml:"exclude_hosts,omitempty"`
 }
 
 // PluginConfig general description
@@ -1,10 +1,16 @@
 package repository
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
 	"path"
+	"sort"
 	"strings"
+	"unicode"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -24,6 +30,8 @@ const (
 
 var (
 	mainRepository *filesystemRepository
+	// configExtensions are extensions of parsing and aggregation configs
+	configExtensions = []string{".yaml", ".yml", ".json"}
 )
 
 type filesystemRepository struct {
@@ -86,13 +94,19 @@ func GetAggregationConfigs(pConfig *ParsingConfig, pName string) (*map[string]Ag
 
 // GetAggregationConfig load aggregation config
 func GetAggregationConfig(name string) (EncodedConfig, error) {
-	fpath := path.Join(mainRepository.aggregationpath, name+".yaml")
+	fpath, err := findConfig(mainRepository.aggregationpath, name)
+	if err != nil {
+		return nil, err
+	}
 	return readConfig(fpath)
 }
 
 // GetParsingConfig load parsing config
 func GetParsingConfig(name string) (EncodedConfig, error) {
-	fpath := path.Join(mainRepository.parsingpath, name+".yaml")
+	fpath, err := findConfig(mainRepository.parsingpath, name)
+	if err != nil {
+		return nil, err
+	}
 	return readConfig(fpath)
 }
 
@@ -112,36 +126,207 @@ func ListAggregationConfigs() ([]string, error) {
 	return lsConfigs(mainRepository.aggregationpath)
 }
 
-func lsConfigs(filepath string) (list []string, err error) {
-	listing, err := ioutil.ReadDir(filepath)
+// ConflictError is returned by listing functions along with the list of configs
+// when some configs are present in several formats, such configs are listed,
+// but can not be read
+type ConflictError struct {
+	// Conflicts are paths of conflicting files by config name
+	Conflicts map[string][]string
+}
+
+// Names return sorted names of conflicting configs
+func (e *ConflictError) Names() []string {
+	names := make([]string, 0, len(e.Conflicts))
+	for name := range e.Conflicts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Conflict return error describing conflict of the named config
+func (e *ConflictError) Conflict(name string) error {
+	return conflictError(name, e.Conflicts[name])
+}
+
+func (e *ConflictError) Error() string {
+	var msgs []string
+	for _, name := range e.Names() {
+		msgs = append(msgs, e.Conflict(name).Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func conflictError(name string, paths []string) error {
+	return errors.Errorf("conflicting configs %s: %s", name, strings.Join(paths, ", "))
+}
+
+func lsConfigs(dir string) ([]string, error) {
+	listing, err := ioutil.ReadDir(dir)
 	if err != nil {
-		return
+		return nil, err
 	}
 
+	var list []string
+	formats := make(map[string]map[string]bool)
 	for _, file := range listing {
 		name := file.Name()
 		if isConfig(name) && !file.IsDir() {
-			list = append(list, strings.TrimSuffix(name, path.Ext(name)))
+			ext := path.Ext(name)
+			name = strings.TrimSuffix(name, ext)
+			if formats[name] == nil {
+				formats[name] = make(map[string]bool)
+				list = append(list, name)
+			}
+			formats[name][ext] = true
 		}
 	}
-	return
+	conflicts := make(map[string][]string)
+	for name, exts := range formats {
+		if len(exts) < 2 {
+			continue
+		}
+		for _, ext := range configExtensions {
+			if exts[ext] {
+				conflicts[name] = append(conflicts[name], path.Join(dir, name+ext))
+			}
+		}
+	}
+	if len(conflicts) > 0 {
+		return list, &ConflictError{Conflicts: conflicts}
+	}
+	return list, nil
 }
 
 func isConfig(name string) bool {
-	if strings.HasSuffix(name, ".yaml") {
-		return true
+	ext := path.Ext(name)
+	for _, e := range configExtensions {
+		if ext == e {
+			return true
+		}
 	}
 	return false
 }
 
-func readConfig(path string) (data EncodedConfig, err error) {
-	data, err = ioutil.ReadFile(path)
+// findConfig return path of the named config in the dir,
+// the config present in several formats is an error
+func findConfig(dir string, name string) (string, error) {
+	var found []string
+	for _, ext := range configExtensions {
+		fpath := path.Join(dir, name+ext)
+		if _, err := os.Stat(fpath); err == nil {
+			found = append(found, fpath)
+		}
+	}
+	switch len(found) {
+	case 0:
+		// let readConfig return not exist error
+		return path.Join(dir, name+configExtensions[0]), nil
+	case 1:
+		return found[0], nil
+	default:
+		return "", conflictError(name, found)
+	}
+}
+
+func readConfig(fpath string) (data EncodedConfig, err error) {
+	data, err = ioutil.ReadFile(fpath)
 	if err != nil {
 		return
 	}
+	if path.Ext(fpath) == ".json" {
+		data, err = jsonToYAML(data)
+	} else {
+		data, err = mergeYAMLDocuments(data)
+	}
+	if err != nil {
+		err = errors.Wrapf(err, "%s", fpath)
+	}
 	return
 }
 
+// mergeYAMLDocuments merge documents of multi-document yaml config in order,
+// maps are merged recursively and other values of later documents override
+// earlier ones, single document is returned as is
+func mergeYAMLDocuments(data []byte) ([]byte, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	var documents []interface{}
+	for {
+		var doc interface{}
+		err := decoder.Decode(&doc)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if doc != nil {
+			documents = append(documents, doc)
+		}
+	}
+	if len(documents) < 2 {
+		return data, nil
+	}
+	var merged interface{}
+	for idx, doc := range documents {
+		if _, ok := doc.(map[interface{}]interface{}); !ok {
+			return nil, errors.Errorf("document %d is not a map", idx+1)
+		}
+		merged = mergeYAML(merged, doc)
+	}
+	return yaml.Marshal(merged)
+}
+
+func mergeYAML(dst, src interface{}) interface{} {
+	dstMap, ok := dst.(map[interface{}]interface{})
+	if !ok {
+		return src
+	}
+	srcMap, ok := src.(map[interface{}]interface{})
+	if !ok {
+		return src
+	}
+	for key, value := range srcMap {
+		dstMap[key] = mergeYAML(dstMap[key], value)
+	}
+	return dstMap
+}
+
+// jsonToYAML convert json config to yaml,
+// so EncodedConfig is decoded in the same way for all formats
+func jsonToYAML(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(jsonNumbers(value))
+}
+
+// jsonNumbers replace json.Number with int64 or float64,
+// otherwise numbers are encoded as yaml strings
+func jsonNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = jsonNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = jsonNumbers(item)
+		}
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+	}
+	return value
+}
+
 // VerifyCombainerConfig check combainer config
 func VerifyCombainerConfig(cfg *CombainerConfig) error {
 	if cfg.MainSection.IterationDuration <= 0 {
@@ -153,6 +338,9 @@ func VerifyCombainerConfig(cfg *CombainerConfig) error {
 	if cfg.MainSection.Cache.Interval <= 0 {
 		cfg.MainSection.Cache.Interval = 15
 	}
+	if cfg.MainSection.Cache.MaxStale == 0 {
+		cfg.MainSection.Cache.MaxStale = 60
+	}
 	return nil
 }
 
@@ -178,6 +366,30 @@ func (p *ParsingConfig) UpdateByCombainerConfig(config *CombainerConfig) {
 	if p.Metahost == "" && len(p.Groups) > 0 {
 		p.Metahost = p.Groups[0]
 	}
+	if p.Metahost == "" {
+		p.Metahost = firstHostsGroup(p.HostsExpr, p.HostFetchers)
+	}
+}
+
+// firstHostsGroup return the first group of hosts expression
+// without the name of the hosts fetcher
+func firstHostsGroup(expr string, fetchers map[string]PluginConfig) string {
+	tokens := strings.FieldsFunc(expr, func(r rune) bool {
+		return unicode.IsSpace(r) || r == '(' || r == ')'
+	})
+	for _, t := range tokens {
+		switch t {
+		case "+", "-", "&":
+			continue
+		}
+		if idx := strings.Index(t, ":"); idx > -1 {
+			if _, ok := fetchers[t[:idx]]; ok {
+				return t[idx+1:]
+			}
+		}
+		return t
+	}
+	return ""
 }
 
 // Encode encode parsing config
@@ -233,6 +445,20 @@ func (p *PluginConfig) GetBool(key string) (bool, error) {
 	return val, nil
 }
 
+// GetString value if present or empty string
+func (p *PluginConfig) GetString(key string) (string, error) {
+	rawVal, ok := (*p)[key]
+	if !ok {
+		return "", nil
+	}
+	switch rawVal.(type) {
+	case string, []byte:
+		return fmt.Sprintf("%s", rawVal), nil
+	default:
+		return "", errors.Errorf("%s is not string value", key)
+	}
+}
+
 // PluginConfigsUpdate update target PluginConfig with
 // content from source PluginConfig
 func PluginConfigsUpdate(target *PluginConfig, source *PluginConfig) {
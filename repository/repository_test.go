@@ -2,6 +2,9 @@ package repository
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -11,8 +14,83 @@ const repopath = "../testdata/configs"
 
 func TestUtilityFunctions(t *testing.T) {
 	assert.False(t, isConfig("blabla"))
-	assert.False(t, isConfig("blabla.json"))
+	assert.False(t, isConfig("blabla.txt"))
+	assert.True(t, isConfig("blabla.json"))
+	assert.True(t, isConfig("blabla.yml"))
 	assert.True(t, isConfig("blabla.yaml"))
+
+	fetchers := map[string]PluginConfig{"zk": {}}
+	assert.Equal(t, "", firstHostsGroup("", fetchers))
+	assert.Equal(t, "front", firstHostsGroup("front + zk:back", fetchers))
+	assert.Equal(t, "back", firstHostsGroup("(zk:back - maint) & front", fetchers))
+	assert.Equal(t, "svc:8080", firstHostsGroup("svc:8080", fetchers))
+}
+
+func TestConfigFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "combaine-repository")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer Init(repopath)
+
+	files := map[string]string{
+		"parsing/json.json":      `{"groups": ["front"], "agg_configs": ["yml"], "DataFetcher": {"type": "http", "port": 8080, "timeout": 1.5, "uri": "/stat\/x"}}`,
+		"parsing/broken.json":    `{"groups": [`,
+		"parsing/dup.yaml":       "groups: [front]\n",
+		"parsing/dup.json":       `{"groups": ["back"]}`,
+		"aggregate/yml.yml":      "data:\n  total:\n    type: summa\n",
+		"aggregate/multi.yaml":   "data:\n  total: {type: summa}\nsenders: {g: {type: graphite}}\n---\ndata:\n  rps: {type: custom}\nsenders: {g: {cluster: front}}\n",
+		"aggregate/badmulti.yml": "data: {}\n---\n- 1\n",
+		"aggregate/unknown.conf": "",
+	}
+	for name, content := range files {
+		fpath := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(fpath), 0755))
+		assert.NoError(t, ioutil.WriteFile(fpath, []byte(content), 0644))
+	}
+	Init(dir)
+
+	lp, err := ListParsingConfigs()
+	assert.Equal(t, []string{"broken", "dup", "json"}, lp)
+	if assert.IsType(t, &ConflictError{}, err) {
+		conflicts := err.(*ConflictError)
+		assert.Equal(t, []string{"dup"}, conflicts.Names())
+		assert.EqualError(t, conflicts.Conflict("dup"), "conflicting configs dup: "+
+			dir+"/parsing/dup.yaml, "+dir+"/parsing/dup.json")
+	}
+	la, err := ListAggregationConfigs()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"badmulti", "multi", "yml"}, la)
+
+	acfg, err := GetAggregationConfig("multi")
+	assert.NoError(t, err)
+	var multi AggregationConfig
+	assert.NoError(t, acfg.Decode(&multi))
+	assert.Equal(t, "summa", multi.Data["total"]["type"])
+	assert.Equal(t, "custom", multi.Data["rps"]["type"])
+	assert.Equal(t, PluginConfig{"type": "graphite", "cluster": "front"}, multi.Senders["g"])
+	_, err = GetAggregationConfig("badmulti")
+	assert.Error(t, err)
+
+	pcfg, err := GetParsingConfig("json")
+	assert.NoError(t, err)
+	var parsingConfig ParsingConfig
+	assert.NoError(t, pcfg.Decode(&parsingConfig))
+	assert.Equal(t, []string{"front"}, parsingConfig.Groups)
+	assert.Equal(t, 8080, parsingConfig.DataFetcher["port"])
+	assert.Equal(t, 1.5, parsingConfig.DataFetcher["timeout"])
+	assert.Equal(t, "/stat/x", parsingConfig.DataFetcher["uri"])
+
+	aggs, err := GetAggregationConfigs(&parsingConfig, "json")
+	assert.NoError(t, err)
+	assert.Contains(t, (*aggs)["yml"].Data, "total")
+
+	_, err = GetParsingConfig("broken")
+	assert.Error(t, err)
+	_, err = GetParsingConfig("dup")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "conflicting")
+	_, err = GetParsingConfig("unknown")
+	assert.True(t, os.IsNotExist(err))
 }
 
 func TestRepository(t *testing.T) {
@@ -2,9 +2,14 @@ package worker
 
 import (
 	"context"
+	"fmt"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/combaine/combaine/common/hosts"
+	"github.com/combaine/combaine/repository"
 	"github.com/combaine/combaine/senders"
 	"github.com/combaine/combaine/utils"
 	"github.com/sirupsen/logrus"
@@ -16,6 +21,7 @@ func DoAggregating(ctx context.Context, task *AggregatingTask) error {
 	var parsingConfig = task.GetParsingConfig()
 	var aggregationConfig = task.GetAggregationConfig()
 	var Hosts = task.GetHosts()
+	var labels = task.GetHostLabels()
 
 	log := logrus.WithFields(logrus.Fields{
 		"stage":   "DoAggregating",
@@ -29,6 +35,7 @@ func DoAggregating(ctx context.Context, task *AggregatingTask) error {
 	var aggWg sync.WaitGroup
 
 	meta := parsingConfig.Metahost
+	metaLabels := labels.Common(Hosts.AllHosts())
 	ch := make(chan *senders.AggregationResult)
 
 	initCap := len(aggregationConfig.Data) * len(Hosts)
@@ -49,6 +56,11 @@ func DoAggregating(ctx context.Context, task *AggregatingTask) error {
 			log.Errorf("resolve %s Class for %s: %s", aggType, name, err)
 			continue
 		}
+		levels, err := aggregationLevels(cfg)
+		if err != nil {
+			log.Errorf("resolve levels for %s: %s", name, err)
+			continue
+		}
 		log.Infof("send %s to %s.%s", name, aggType, aggClass)
 		ac := NewAggregatorClient(NextAggregatorConn())
 
@@ -78,12 +90,12 @@ func DoAggregating(ctx context.Context, task *AggregatingTask) error {
 					Task: &AggregatorTask{
 						Id:     task.Id,
 						Config: encodedCfg,
-						Meta: map[string]string{
+						Meta: withLabels(map[string]string{
 							"type":      "host",
 							"aggregate": name,
 							"name":      host,
 							"metahost":  meta,
-						},
+						}, labels[host], aggregationConfig.Labels),
 					},
 					ClassName: aggClass,
 					Payload:   [][]byte{data},
@@ -116,12 +128,12 @@ func DoAggregating(ctx context.Context, task *AggregatingTask) error {
 				Task: &AggregatorTask{
 					Id:     task.Id,
 					Config: encodedCfg,
-					Meta: map[string]string{
+					Meta: withLabels(map[string]string{
 						"type":      "datacenter",
 						"aggregate": name,
 						"name":      subGroup,
 						"metahost":  meta,
-					},
+					}, labels.Common(hosts), aggregationConfig.Labels),
 				},
 				ClassName: aggClass,
 				Payload:   subGroupParsingResults,
@@ -138,6 +150,48 @@ func DoAggregating(ctx context.Context, task *AggregatingTask) error {
 			}(groupReq)
 		}
 
+		for _, level := range levels {
+			for _, group := range groupByLabels(Hosts, labels, level) {
+				groupParsingResults := make([][]byte, 0, len(group.hosts))
+				for _, host := range group.hosts {
+					if data, ok := task.ParsingResult.Data[host+";"+name]; ok {
+						groupParsingResults = append(groupParsingResults, data)
+					}
+				}
+				if len(groupParsingResults) == 0 {
+					log.Infof("%s %v=%v nothing aggregate", name, level, group.values)
+					continue
+				}
+
+				log.Debugf("labels %v=%v", level, group.values)
+				levelReq := &AggregateGroupRequest{
+					Task: &AggregatorTask{
+						Id:     task.Id,
+						Config: encodedCfg,
+						Meta: withLevelLabels(withLabels(map[string]string{
+							"type":      "labels",
+							"aggregate": name,
+							"name":      labelsGroupName(meta, level, group.values),
+							"metahost":  meta,
+							"level":     strings.Join(level, ","),
+						}, labels.Common(group.hosts), aggregationConfig.Labels), level, group.values),
+					},
+					ClassName: aggClass,
+					Payload:   groupParsingResults,
+				}
+				aggWg.Add(1)
+				go func(r *AggregateGroupRequest) {
+					defer aggWg.Done()
+					res, err := ac.AggregateGroup(ctx, r)
+					if err != nil {
+						log.Errorf("failed to call aggregator.AggregateGroup(%s): %v", r.Task.Meta["name"], err)
+					} else {
+						ch <- &senders.AggregationResult{Tags: r.Task.Meta, Result: res.Result}
+					}
+				}(levelReq)
+			}
+		}
+
 		if len(aggParsingResults) == 0 {
 			log.Infof("%s nothing aggregate", meta)
 			continue
@@ -148,12 +202,12 @@ func DoAggregating(ctx context.Context, task *AggregatingTask) error {
 			Task: &AggregatorTask{
 				Id:     task.Id,
 				Config: encodedCfg,
-				Meta: map[string]string{
+				Meta: withLabels(map[string]string{
 					"type":      "metahost",
 					"aggregate": name,
 					"name":      meta,
 					"metahost":  meta,
-				},
+				}, metaLabels, aggregationConfig.Labels),
 			},
 			ClassName: aggClass,
 			Payload:   aggParsingResults,
@@ -183,3 +237,137 @@ func DoAggregating(ctx context.Context, task *AggregatingTask) error {
 	log.Infof("aggregation completed (took %.3f)", time.Now().Sub(startTm).Seconds())
 	return DoSending(ctx, meta, task, aggregationConfig.Senders, result)
 }
+
+// withLabels add allowed host labels to meta of aggregator task, meta is also
+// used as tags by senders, so labels never override predefined keys
+func withLabels(meta map[string]string, labels map[string]string, allowed []string) map[string]string {
+	for _, k := range allowed {
+		v, ok := labels[k]
+		if !ok {
+			continue
+		}
+		if _, ok := meta[k]; !ok {
+			meta[k] = v
+		}
+	}
+	return meta
+}
+
+// withLevelLabels add keys and values of the level labels to meta,
+// results of the level are tagged with them even if they are not allowed
+// by labels option, predefined keys are not overridden
+func withLevelLabels(meta map[string]string, level []string, values []string) map[string]string {
+	for idx, key := range level {
+		if _, ok := meta[key]; !ok {
+			meta[key] = values[idx]
+		}
+	}
+	return meta
+}
+
+// levelsKey is option of aggregation data section with list of levels,
+// where level is a label key or a list of label keys
+const levelsKey = "levels"
+
+// aggregationLevels return additional aggregation levels of the data section
+func aggregationLevels(cfg repository.PluginConfig) ([][]string, error) {
+	raw, ok := cfg[levelsKey]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+	items, ok := raw.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("`%s` is not a list: %v", levelsKey, raw)
+	}
+	levels := make([][]string, 0, len(items))
+	for _, item := range items {
+		var level []string
+		switch v := item.(type) {
+		case string:
+			level = []string{v}
+		case []interface{}:
+			for _, k := range v {
+				key, ok := k.(string)
+				if !ok {
+					return nil, fmt.Errorf("label key is not a string: %v", k)
+				}
+				level = append(level, key)
+			}
+		default:
+			return nil, fmt.Errorf("level is not a label key or list of label keys: %v", item)
+		}
+		if len(level) == 0 {
+			return nil, fmt.Errorf("level without label keys")
+		}
+		levels = append(levels, level)
+	}
+	return levels, nil
+}
+
+// labelsGroup is hosts with the same values of the level labels
+type labelsGroup struct {
+	values []string
+	hosts  []string
+}
+
+// labelsGroupName name the group by label keys and values, e.g. `meta-shard_1-rack_r1`,
+// so names of different levels do not collide with each other and with datacenters.
+// The name is used by senders as a part of metric path, so keys and values
+// are reduced to letters, digits, `_` and `-`
+func labelsGroupName(meta string, level []string, values []string) string {
+	pairs := make([]string, len(level))
+	for idx, key := range level {
+		pairs[idx] = safeName(key) + "_" + safeName(values[idx])
+	}
+	return meta + "-" + strings.Join(pairs, "-")
+}
+
+// safeName replace characters other than letters, digits, `_` and `-` with `_`
+func safeName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// groupByLabels split hosts by values of the level labels,
+// hosts without any of the labels are omitted
+func groupByLabels(h hosts.Hosts, labels hosts.Labels, level []string) []labelsGroup {
+	groups := make(map[string]*labelsGroup)
+	for _, host := range h.AllHosts() {
+		hostLabels := labels[host]
+		values := make([]string, 0, len(level))
+		for _, key := range level {
+			v, ok := hostLabels[key]
+			if !ok {
+				break
+			}
+			values = append(values, v)
+		}
+		if len(values) != len(level) {
+			continue
+		}
+		key := strings.Join(values, "\x00")
+		g, ok := groups[key]
+		if !ok {
+			g = &labelsGroup{values: values}
+			groups[key] = g
+		}
+		g.hosts = append(g.hosts, host)
+	}
+
+	keys := make([]string, 0, len(groups))
+	for k := range groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	result := make([]labelsGroup, len(keys))
+	for idx, k := range keys {
+		result[idx] = *groups[k]
+		sort.Strings(result[idx].hosts)
+	}
+	return result
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/combaine/combaine/common/hosts"
 	"github.com/combaine/combaine/repository"
 )
 
@@ -31,3 +32,76 @@ func TestInit(t *testing.T) {
 
 func TestAggregating(t *testing.T) {
 }
+
+func TestWithLabels(t *testing.T) {
+	labels := map[string]string{"name": "other", "rack": "r1", "owner": "team"}
+	meta := withLabels(map[string]string{"type": "host", "name": "host1"}, labels, []string{"name", "rack", "zone"})
+	assert.Equal(t, map[string]string{"type": "host", "name": "host1", "rack": "r1"}, meta)
+	// labels are not propagated without allowlist
+	assert.Equal(t, map[string]string{"type": "host"}, withLabels(map[string]string{"type": "host"}, labels, nil))
+	assert.Equal(t, map[string]string{"type": "host"}, withLabels(map[string]string{"type": "host"}, nil, []string{"rack"}))
+}
+
+func TestAggregationLevels(t *testing.T) {
+	cases := []struct {
+		cfg      repository.PluginConfig
+		expected [][]string
+		err      bool
+	}{
+		{repository.PluginConfig{}, nil, false},
+		{repository.PluginConfig{"levels": []interface{}{"shard", []interface{}{"rack", "version"}}},
+			[][]string{{"shard"}, {"rack", "version"}}, false},
+		{repository.PluginConfig{"levels": "shard"}, nil, true},
+		{repository.PluginConfig{"levels": []interface{}{[]interface{}{}}}, nil, true},
+		{repository.PluginConfig{"levels": []interface{}{[]interface{}{1}}}, nil, true},
+		{repository.PluginConfig{"levels": []interface{}{1}}, nil, true},
+	}
+	for _, c := range cases {
+		levels, err := aggregationLevels(c.cfg)
+		if c.err {
+			assert.Error(t, err, c.cfg)
+			continue
+		}
+		assert.NoError(t, err, c.cfg)
+		assert.Equal(t, c.expected, levels, c.cfg)
+	}
+}
+
+func TestWithLevelLabels(t *testing.T) {
+	meta := withLevelLabels(withLabels(map[string]string{"type": "labels", "name": "meta-type_x"},
+		map[string]string{"shard": "1", "rack": "r1", "type": "x"}, []string{"rack"}),
+		[]string{"shard", "type"}, []string{"1", "x"})
+	assert.Equal(t, map[string]string{"type": "labels", "name": "meta-type_x", "rack": "r1", "shard": "1"}, meta)
+}
+
+func TestGroupByLabels(t *testing.T) {
+	h := hosts.Hosts{"DC1": {"h1", "h2", "h3"}, "DC2": {"h4", "h5"}}
+	labels := hosts.Labels{
+		"h1": {"shard": "1", "version": "stable"},
+		"h2": {"shard": "2", "version": "stable"},
+		"h3": {"shard": "1", "version": "canary"},
+		"h4": {"shard": "1", "version": "stable"},
+		"h5": {"version": "stable"},
+	}
+	assert.Equal(t, []labelsGroup{
+		{values: []string{"1"}, hosts: []string{"h1", "h3", "h4"}},
+		{values: []string{"2"}, hosts: []string{"h2"}},
+	}, groupByLabels(h, labels, []string{"shard"}))
+	assert.Equal(t, []labelsGroup{
+		{values: []string{"canary", "1"}, hosts: []string{"h3"}},
+		{values: []string{"stable", "1"}, hosts: []string{"h1", "h4"}},
+		{values: []string{"stable", "2"}, hosts: []string{"h2"}},
+	}, groupByLabels(h, labels, []string{"version", "shard"}))
+	assert.Empty(t, groupByLabels(h, labels, []string{"rack"}))
+}
+
+func TestLabelsGroupName(t *testing.T) {
+	assert.Equal(t, "meta-shard_1", labelsGroupName("meta", []string{"shard"}, []string{"1"}))
+	assert.Equal(t, "meta-version_stable-shard_1",
+		labelsGroupName("meta", []string{"version", "shard"}, []string{"stable", "1"}))
+	assert.Equal(t, "meta-zone_ru-central1_a",
+		labelsGroupName("meta", []string{"zone"}, []string{"ru-central1.a"}))
+	assert.Equal(t, "meta-k_v_1_2", labelsGroupName("meta", []string{"k"}, []string{"v=1,2"}))
+	assert.NotEqual(t, labelsGroupName("meta", []string{"rack"}, []string{"1"}),
+		labelsGroupName("meta", []string{"shard"}, []string{"1"}))
+}
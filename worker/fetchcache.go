@@ -0,0 +1,143 @@
+package worker
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/combaine/combaine/fetchers"
+	"github.com/combaine/combaine/repository"
+)
+
+// deduplicateKey in DataFetcher section enables sharing of fetched data
+// between parsing configs, it is rejected for stateful fetchers
+// (file fetcher, record decorator, incremental timetail)
+const deduplicateKey = "deduplicate"
+
+// fetchResult is data fetched from the target
+type fetchResult struct {
+	blob    []byte
+	sources map[string][]byte
+	cursors map[string]string
+}
+
+type fetchCacheEntry struct {
+	result *fetchResult
+	expire time.Time
+}
+
+// fetchCache deduplicate identical fetches from different parsing configs,
+// concurrent fetches are merged and the result is kept until the end of the period
+type fetchCache struct {
+	group singleflight.Group
+
+	sync.Mutex
+	entries map[string]fetchCacheEntry
+}
+
+var sharedFetches = &fetchCache{entries: make(map[string]fetchCacheEntry)}
+
+// fetchKey identify the fetch by fetcher type, hash of the fetcher config,
+// target, the period bucket of the frame and cursors of incremental fetchers
+func fetchKey(fetcherType string, cfg repository.PluginConfig, task *fetchers.FetcherTask) (string, error) {
+	encoded, err := canonicalJSON(map[string]interface{}(cfg))
+	if err != nil {
+		return "", errors.Wrap(err, "deduplicate")
+	}
+	cursors, err := canonicalJSON(task.SourceCursors)
+	if err != nil {
+		return "", errors.Wrap(err, "deduplicate")
+	}
+	hash := md5.Sum(encoded)
+	bucket := task.End
+	if task.Period > 0 {
+		bucket = task.End / task.Period
+	}
+	return fmt.Sprintf("%s;%x;%s;%d;%d;%s;%s", fetcherType, hash, task.Target, task.Period, bucket,
+		task.Cursor, cursors), nil
+}
+
+// canonicalJSON encode the value with sorted map keys,
+// yaml maps with non string keys are encoded with stringified keys
+func canonicalJSON(value interface{}) ([]byte, error) {
+	return json.Marshal(stringKeys(value))
+}
+
+func stringKeys(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[fmt.Sprint(key)] = stringKeys(item)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[key] = stringKeys(item)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, item := range v {
+			l[i] = stringKeys(item)
+		}
+		return l
+	}
+	return value
+}
+
+// do return cached result for the key or call fetch once for all concurrent callers,
+// shared is true if the result was fetched for another caller.
+// fetch isn't bound to the context of any caller, so cancelled caller
+// does not fail the others, it is bounded by the ttl and the caller deadline
+func (c *fetchCache) do(ctx context.Context, key string, ttl time.Duration,
+	fetch func(context.Context) (*fetchResult, error)) (result *fetchResult, shared bool, err error) {
+
+	now := time.Now()
+	c.Lock()
+	for k, e := range c.entries {
+		if now.After(e.expire) {
+			delete(c.entries, k)
+		}
+	}
+	e, ok := c.entries[key]
+	c.Unlock()
+	if ok {
+		return e.result, true, nil
+	}
+
+	timeout, bounded := ttl, ttl > 0
+	if deadline, ok := ctx.Deadline(); ok && (!bounded || time.Until(deadline) < timeout) {
+		timeout, bounded = time.Until(deadline), true
+	}
+	ch := c.group.DoChan(key, func() (interface{}, error) {
+		fetchCtx, cancel := context.WithCancel(context.Background())
+		if bounded {
+			fetchCtx, cancel = context.WithTimeout(context.Background(), timeout)
+		}
+		defer cancel()
+		res, err := fetch(fetchCtx)
+		if err == nil && ttl > 0 {
+			c.Lock()
+			c.entries[key] = fetchCacheEntry{result: res, expire: time.Now().Add(ttl)}
+			c.Unlock()
+		}
+		return res, err
+	})
+	select {
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Shared, res.Err
+		}
+		return res.Val.(*fetchResult), res.Shared, nil
+	}
+}
@@ -0,0 +1,132 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/combaine/combaine/fetchers"
+	"github.com/combaine/combaine/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFetchKey(t *testing.T) {
+	cfg := repository.PluginConfig{"type": "http", "port": 80, "headers": map[interface{}]interface{}{"a": "1", "b": "2"}}
+	same := repository.PluginConfig{"headers": map[interface{}]interface{}{"b": "2", "a": "1"}, "port": 80, "type": "http"}
+	other := repository.PluginConfig{"type": "http", "port": 81}
+
+	task := &fetchers.FetcherTask{Target: "host", Period: 60, Start: 1000, End: 1060}
+	sameBucket := &fetchers.FetcherTask{Target: "host", Period: 60, Start: 1010, End: 1070}
+	nextBucket := &fetchers.FetcherTask{Target: "host", Period: 60, Start: 1060, End: 1120}
+
+	mustKey := func(fetcherType string, cfg repository.PluginConfig, task *fetchers.FetcherTask) string {
+		key, err := fetchKey(fetcherType, cfg, task)
+		assert.NoError(t, err)
+		return key
+	}
+	key := mustKey("http", cfg, task)
+	assert.Equal(t, key, mustKey("http", same, task))
+	assert.Equal(t, key, mustKey("http", cfg, sameBucket))
+	assert.NotEqual(t, key, mustKey("http", other, task))
+	assert.NotEqual(t, key, mustKey("timetail", cfg, task))
+	assert.NotEqual(t, key, mustKey("http", cfg, nextBucket))
+	assert.NotEqual(t, key, mustKey("http", cfg, &fetchers.FetcherTask{Target: "other", Period: 60, End: 1060}))
+
+	// nested maps with pointers are hashed by content
+	port := 80
+	nested := repository.PluginConfig{"type": "http", "opts": map[interface{}]interface{}{"port": &port}}
+	samePort := 80
+	assert.Equal(t, mustKey("http", nested, task), mustKey("http", repository.PluginConfig{
+		"opts": map[string]interface{}{"port": &samePort}, "type": "http"}, task))
+	_, err := fetchKey("http", repository.PluginConfig{"bad": func() {}}, task)
+	assert.Error(t, err)
+}
+
+func TestFetchCache(t *testing.T) {
+	c := &fetchCache{entries: make(map[string]fetchCacheEntry)}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var calls int32
+	fetch := func(context.Context) (*fetchResult, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(50 * time.Millisecond)
+		return &fetchResult{blob: []byte("data")}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, _, err := c.do(ctx, "key", time.Minute, fetch)
+			assert.NoError(t, err)
+			assert.Equal(t, "data", string(res.blob))
+		}()
+	}
+	wg.Wait()
+	assert.EqualValues(t, 1, calls)
+
+	// cached until ttl expires
+	res, shared, err := c.do(ctx, "key", time.Minute, fetch)
+	assert.NoError(t, err)
+	assert.True(t, shared)
+	assert.Equal(t, "data", string(res.blob))
+	assert.EqualValues(t, 1, calls)
+
+	_, _, err = c.do(ctx, "short", time.Millisecond, fetch)
+	assert.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+	_, shared, err = c.do(ctx, "short", time.Millisecond, fetch)
+	assert.NoError(t, err)
+	assert.False(t, shared)
+	assert.EqualValues(t, 3, calls)
+
+	// errors are not cached
+	failed := func(context.Context) (*fetchResult, error) { return nil, errors.New("failed") }
+	_, _, err = c.do(ctx, "failed", time.Minute, failed)
+	assert.Error(t, err)
+	_, _, err = c.do(ctx, "failed", time.Minute, fetch)
+	assert.NoError(t, err)
+
+	canceled, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+	_, _, err = c.do(canceled, "canceled", time.Minute, fetch)
+	assert.Equal(t, context.Canceled, err)
+
+	// cancelled caller does not fail others waiting for the same fetch
+	started := make(chan struct{})
+	var once sync.Once
+	slow := func(ctx context.Context) (*fetchResult, error) {
+		once.Do(func() { close(started) })
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+		}
+		deadline, ok := ctx.Deadline()
+		assert.True(t, ok, "shared fetch must be bounded by ttl")
+		assert.True(t, time.Until(deadline) <= time.Second, "shared fetch must be bounded by caller deadline")
+		return &fetchResult{blob: []byte("slow")}, nil
+	}
+	first, cancelFirst := context.WithCancel(ctx)
+	errs := make(chan error)
+	go func() {
+		_, _, err := c.do(first, "slow", time.Minute, slow)
+		errs <- err
+	}()
+	<-started
+	go func() {
+		res, _, err := c.do(ctx, "slow", time.Minute, slow)
+		if err == nil {
+			assert.Equal(t, "slow", string(res.blob))
+		}
+		errs <- err
+	}()
+	cancelFirst()
+	assert.Equal(t, context.Canceled, <-errs)
+	assert.NoError(t, <-errs)
+}
@@ -9,9 +9,16 @@ import (
 	"github.com/combaine/combaine/repository"
 	"github.com/combaine/combaine/utils"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
-func fetchDataFromTarget(ctx context.Context, task *ParsingTask) ([]byte, error) {
+// sourceKey in aggregation data section selects named payload of multi fetcher
+const sourceKey = "source"
+
+// fetchDataFromTarget return fetched blob, named payloads
+// if the data fetcher is fetchers.MultiFetcher and cursor of incremental fetchers
+func fetchDataFromTarget(ctx context.Context, task *ParsingTask) (*fetchResult, error) {
 	log := logrus.WithFields(logrus.Fields{
 		"config":  task.ParsingConfigName,
 		"target":  task.Host,
@@ -21,33 +28,108 @@ func fetchDataFromTarget(ctx context.Context, task *ParsingTask) ([]byte, error)
 
 	fetcherType, err := parsingConfig.DataFetcher.Type()
 	if err != nil {
-		return nil, err
+		return nil, status.Errorf(codes.InvalidArgument, "DataFetcher: %v", err)
 	}
 	log.Debugf("use %s for fetching data", fetcherType)
 	fetcher, err := fetchers.NewFetcher(fetcherType, parsingConfig.DataFetcher)
 	if err != nil {
-		return nil, err
+		return nil, status.Errorf(codes.InvalidArgument, "init %s fetcher: %v", fetcherType, err)
 	}
 
 	fetcherTask := fetchers.FetcherTask{
-		ID:     task.Id,
-		Period: task.Frame.Current - task.Frame.Previous,
-		Target: task.Host,
+		ID:         task.Id,
+		Config:     task.ParsingConfigName,
+		Period:     task.Frame.Current - task.Frame.Previous,
+		Target:     task.Host,
+		Datacenter: task.Datacenter,
+		Start:      task.Frame.Previous,
+		End:        task.Frame.Current,
+		Labels:     task.Labels,
+		// single fetcher uses cursor of the empty source
+		Cursor:        task.Cursors[""],
+		SourceCursors: task.Cursors,
+	}
+
+	deduplicate := false
+	if _, ok := parsingConfig.DataFetcher[deduplicateKey]; ok {
+		if deduplicate, err = parsingConfig.DataFetcher.GetBool(deduplicateKey); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "DataFetcher: %v", err)
+		}
+	}
+	if deduplicate && fetchers.IsStateful(fetcher) {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"DataFetcher: %s keeps state per parsing config and can not be deduplicated", fetcherType)
 	}
 
 	defer func(t time.Time) {
 		log.Infof("fetching completed (took %.3f)", time.Now().Sub(t).Seconds())
 	}(time.Now())
 
-	blob, err := fetcher.Fetch(ctx, &fetcherTask)
+	fetch := func(ctx context.Context) (*fetchResult, error) {
+		return fetchWith(ctx, fetcher, &fetcherTask, log)
+	}
+	var res *fetchResult
+	if deduplicate {
+		key, err := fetchKey(fetcherType, parsingConfig.DataFetcher, &fetcherTask)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "DataFetcher: %v", err)
+		}
+		ttl := time.Duration(fetcherTask.Period) * time.Second
+		var shared bool
+		res, shared, err = sharedFetches.do(ctx, key, ttl, fetch)
+		if shared && err == nil {
+			log.Infof("use data fetched for another parsing config")
+		}
+	} else {
+		res, err = fetch(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func fetchWith(ctx context.Context, fetcher fetchers.Fetcher, task *fetchers.FetcherTask, log *logrus.Entry) (*fetchResult, error) {
+	if multi, ok := fetcher.(fetchers.MultiFetcher); ok {
+		sources, err := multi.FetchMulti(ctx, task)
+		if err != nil {
+			return nil, err
+		}
+		for name, blob := range sources {
+			log.Debugf("fetch %d bytes from %s: %q", len(blob), name, blob)
+		}
+		blob, err := fetchers.EncodeMultiPayload(sources)
+		if err != nil {
+			return nil, err
+		}
+		return &fetchResult{blob: blob, sources: sources, cursors: nextCursors(task)}, nil
+	}
+
+	blob, err := fetcher.Fetch(ctx, task)
 	log.Debugf("fetch %d bytes: %q", len(blob), blob)
 	if err != nil {
 		return nil, err
 	}
-	return blob, nil
+	return &fetchResult{blob: blob, cursors: nextCursors(task)}, nil
+}
+
+// nextCursors return cursors set by incremental fetchers keyed by source
+func nextCursors(task *fetchers.FetcherTask) map[string]string {
+	if task.NextCursor == "" && len(task.NextSourceCursors) == 0 {
+		return nil
+	}
+	cursors := make(map[string]string, len(task.NextSourceCursors)+1)
+	for source, cursor := range task.NextSourceCursors {
+		cursors[source] = cursor
+	}
+	if task.NextCursor != "" {
+		cursors[""] = task.NextCursor
+	}
+	return cursors
 }
 
-// DoParsing distribute tasks accross cluster
+// DoParsing distribute tasks accross cluster, errors of the config
+// are InvalidArgument and failures of the target are Aborted
 func DoParsing(ctx context.Context, task *ParsingTask) (*ParsingResult, error) {
 	log := logrus.WithFields(logrus.Fields{
 		"config":  task.ParsingConfigName,
@@ -56,11 +138,19 @@ func DoParsing(ctx context.Context, task *ParsingTask) (*ParsingResult, error) {
 	})
 	log.Debugf("start parsing")
 
-	blob, err := fetchDataFromTarget(ctx, task)
-	if err != nil {
+	fetched, err := fetchDataFromTarget(ctx, task)
+	if _, ok := status.FromError(err); ok && err != nil {
+		// broken config is not a failure of the target
 		log.Errorf("DoParsing: %v", err)
 		return nil, err
 	}
+	if err != nil {
+		reason := fetchers.ErrorReason(err)
+		log.WithField("reason", reason).Errorf("DoParsing: %v", err)
+		// Aborted tells the client that the target is failed, not the worker
+		return nil, status.Errorf(codes.Aborted, "fetch %s: %v", reason, err)
+	}
+	blob, sources := fetched.blob, fetched.sources
 	// parsing timings without fetcher time
 	defer func(t time.Time) {
 		log.Infof("parsing completed (took %.3f)", time.Now().Sub(t).Seconds())
@@ -77,7 +167,7 @@ func DoParsing(ctx context.Context, task *ParsingTask) (*ParsingResult, error) {
 	for _, aggCfg := range aggregationConfigs {
 		for k, v := range aggCfg.Data {
 			wg.Add(1)
-			go func(k string, v repository.PluginConfig) {
+			go func(k string, v repository.PluginConfig, allowedLabels []string) {
 				defer wg.Done()
 
 				aggType, err := v.Type()
@@ -90,6 +180,19 @@ func DoParsing(ctx context.Context, task *ParsingTask) (*ParsingResult, error) {
 					log.Errorf("DoParsing resolve %s Class for %s: %s", aggType, k, err)
 					return
 				}
+				payload := blob
+				source, err := v.GetString(sourceKey)
+				if err != nil {
+					log.Errorf("DoParsing resolve source for %s: %s", k, err)
+					return
+				}
+				if source != "" {
+					var ok bool
+					if payload, ok = sources[source]; !ok {
+						log.Errorf("DoParsing: source %s for %s isn't fetched", source, k)
+						return
+					}
+				}
 				log.Debugf("DoParsing: send to '%s:%s'", aggType, aggClass)
 				encodedCfg, err := utils.Pack(v)
 				if err != nil {
@@ -101,13 +204,13 @@ func DoParsing(ctx context.Context, task *ParsingTask) (*ParsingResult, error) {
 						Id:     task.Id,
 						Frame:  task.Frame,
 						Config: encodedCfg,
-						Meta: map[string]string{
+						Meta: withLabels(map[string]string{
 							"host": task.Host,
 							"key":  k,
-						},
+						}, task.Labels, allowedLabels),
 					},
 					ClassName: aggClass,
-					Payload:   blob,
+					Payload:   payload,
 				}
 				key := task.Host + ";" + k
 				ac := NewAggregatorClient(NextAggregatorConn())
@@ -118,7 +221,7 @@ func DoParsing(ctx context.Context, task *ParsingTask) (*ParsingResult, error) {
 				}
 				log.Debugf("write data with key %s", key)
 				ch <- item{key: key, res: res.GetResult()}
-			}(k, v)
+			}(k, v, aggCfg.Labels)
 		}
 	}
 	go func() {
@@ -130,6 +233,7 @@ func DoParsing(ctx context.Context, task *ParsingTask) (*ParsingResult, error) {
 	for res := range ch {
 		result.Data[res.key] = res.res
 	}
+	result.Cursors = fetched.cursors
 
 	return &result, nil
 }
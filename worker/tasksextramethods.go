@@ -42,3 +42,10 @@ func (t *AggregatingTask) GetHosts() hosts.Hosts {
 	utils.Unpack(t.EncodedHosts, &h)
 	return h
 }
+
+// GetHostLabels decodes labels of Hosts
+func (t *AggregatingTask) GetHostLabels() hosts.Labels {
+	var l hosts.Labels
+	utils.Unpack(t.EncodedHostLabels, &l)
+	return l
+}
@@ -1,6 +1,7 @@
 package worker
 
 import (
+	"context"
 	fmt "fmt"
 	"io/ioutil"
 	"log"
@@ -12,6 +13,8 @@ import (
 	"github.com/combaine/combaine/fetchers"
 	"github.com/combaine/combaine/repository"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 const repoPath = "../testdata/configs"
@@ -31,7 +34,39 @@ func TestFetchersRegistered(t *testing.T) {
 	assert.NoError(t, err)
 	_, err = fetchers.NewFetcher("timetail", c)
 	assert.NoError(t, err)
+	_, err = fetchers.NewFetcher("prometheus", c)
+	assert.NoError(t, err)
+
+	configs := map[string]repository.PluginConfig{
+		"file":       {"path": "/var/log/access.log"},
+		"exec":       {"command": []string{"/bin/true"}},
+		"unixsocket": {"path": "/run/stats.sock"},
+		"replay":     {"dir": "/var/lib/combaine/record", "name": "http"},
+		"multi": {"fetchers": map[string]repository.PluginConfig{
+			"a": {"type": "http", "port": 1},
+			"b": {"type": "file", "path": "/var/log/access.log"},
+		}},
+	}
+	for name, cfg := range configs {
+		_, err = fetchers.NewFetcher(name, cfg)
+		assert.NoError(t, err, name)
+	}
+}
+
+func TestDoParsingConfigErrors(t *testing.T) {
+	// task without DataFetcher is broken config, not failed target
+	_, err := DoParsing(context.Background(), &ParsingTask{Id: "ID", Host: "host"})
+	assert.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
 
+func TestNextCursors(t *testing.T) {
+	assert.Nil(t, nextCursors(&fetchers.FetcherTask{Cursor: "1"}))
+	assert.Equal(t, map[string]string{"": "2"}, nextCursors(&fetchers.FetcherTask{Cursor: "1", NextCursor: "2"}))
+	assert.Equal(t, map[string]string{"access": "3", "error": "4"}, nextCursors(&fetchers.FetcherTask{
+		SourceCursors:     map[string]string{"access": "1"},
+		NextSourceCursors: map[string]string{"access": "3", "error": "4"},
+	}))
 }
 
 func TestMain(m *testing.M) {